@@ -1,17 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cgi"
+	"net/http/fcgi"
 	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
+	"github.com/lattots/bhproxy/pkg/db"
+	"github.com/lattots/bhproxy/pkg/feed"
 	"github.com/lattots/bhproxy/pkg/handler"
 	"github.com/lattots/bhproxy/pkg/utility"
 )
 
+const (
+	defaultListenAddr      = "localhost:8080"
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 60 * time.Second
+	defaultShutdownTimeout = 15 * time.Second
+)
+
 func routeLogMessages(logFileName string) *os.File {
 	if logFileName != "" {
 		logFile, err := os.OpenFile(logFileName, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
@@ -41,6 +61,183 @@ func getDatabaseFilename() string {
 	return databaseFilename
 }
 
+// getDuration reads a Go duration (e.g. "30s") from environment variable name
+// and returns defaultValue if the variable is not set
+func getDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("environment variable %s is not a valid duration: %s", name, err)
+	}
+
+	return duration
+}
+
+func newServeMux(h handler.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	// in CGI context the path starts with the script name, so endpoints are matched
+	// by the last path segment, e.g. /cgi-bin/bhproxy.cgi/widget
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "widget":
+			h.HandleGetWidget(w, r)
+		case "widget.js":
+			h.HandleGetWidgetScript(w, r)
+		case "timeline":
+			h.HandleGetTimeline(w, r)
+		default:
+			h.HandleGetFeed(w, r)
+		}
+	})
+	mux.HandleFunc("OPTIONS /", h.HandlePreflight)
+
+	return mux
+}
+
+// serveCGI answers a single request passed by the web server via CGI
+func serveCGI(h handler.Handler) {
+	if err := cgi.Serve(newServeMux(h)); err != nil {
+		log.Fatalf("failed to serve cgi request: %s", err)
+	}
+}
+
+// serveHTTP runs a long-lived HTTP server until it receives SIGTERM or SIGINT
+func serveHTTP(h handler.Handler, args []string) {
+	listenAddr := os.Getenv("BHP_LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&listenAddr, "listen", listenAddr, "address to listen to, e.g. localhost:8080")
+	flags.Parse(args)
+
+	server := &http.Server{
+		Addr:         listenAddr,
+		Handler:      newServeMux(h),
+		ReadTimeout:  getDuration("BHP_READ_TIMEOUT", defaultReadTimeout),
+		WriteTimeout: getDuration("BHP_WRITE_TIMEOUT", defaultWriteTimeout),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", listenAddr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatalf("failed to serve http: %s", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down http server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to shut down http server: %s", err)
+	}
+}
+
+// fcgiListener parses listen address in form unix:/path/to/socket or tcp:host:port.
+// An empty address means the web server passes the listening socket as stdin.
+func fcgiListener(listenAddr string) (net.Listener, error) {
+	if listenAddr == "" {
+		return nil, nil
+	}
+
+	network, address, found := strings.Cut(listenAddr, ":")
+	if !found || (network != "unix" && network != "tcp") {
+		return nil, errors.New("listen address must be in form unix:/path/to/socket or tcp:host:port")
+	}
+
+	if network == "unix" {
+		// remove socket left behind by previous process
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return net.Listen(network, address)
+}
+
+// serveFastCGI answers FastCGI requests until it receives SIGTERM or SIGINT
+func serveFastCGI(h handler.Handler, args []string) {
+	listenAddr := os.Getenv("BHP_FCGI_LISTEN")
+
+	flags := flag.NewFlagSet("fcgi", flag.ExitOnError)
+	flags.StringVar(&listenAddr, "listen", listenAddr, "unix:/path/to/socket or tcp:host:port, defaults to stdin")
+	flags.Parse(args)
+
+	listener, err := fcgiListener(listenAddr)
+	if err != nil {
+		log.Fatalf("failed to listen fastcgi address %s: %s", listenAddr, err)
+	}
+
+	if listener != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+
+		go func() {
+			<-ctx.Done()
+			log.Println("shutting down fastcgi server")
+			listener.Close()
+		}()
+		log.Printf("listening fastcgi on %s", listenAddr)
+	}
+
+	err = fcgi.Serve(listener, newServeMux(h))
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatalf("failed to serve fastcgi: %s", err)
+	}
+}
+
+// prune removes deprecated posts of one or all feeds and reports what was removed
+func prune(databaseFilename string, args []string) {
+	var feedID string
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	flags.StringVar(&feedID, "feed", "", "feed ID to prune, defaults to all feeds")
+	flags.Parse(args)
+
+	database, err := db.OpenSqliteDB(databaseFilename)
+	if err != nil {
+		log.Fatalf("failed to open sqlite database: %s", err)
+	}
+	defer database.Close()
+	if err := db.InitSqliteDB(database); err != nil {
+		log.Fatalf("error initializing sqlite db: %s", err)
+	}
+
+	var reports []feed.PruneReport
+	if feedID != "" {
+		report, err := feed.PruneFeed(database, feedID)
+		if err != nil {
+			log.Fatalf("failed to prune feed %s: %s", feedID, err)
+		}
+		reports = append(reports, report)
+	} else {
+		reports, err = feed.PruneAllFeeds(database)
+		if err != nil {
+			log.Fatalf("failed to prune feeds: %s", err)
+		}
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%s: removed %d posts, %d children and %d files\n",
+			report.FeedID, len(report.PostIDs), len(report.ChildIDs), len(report.RemovedFiles))
+		for _, fileName := range report.RemovedFiles {
+			fmt.Printf("  %s\n", fileName)
+		}
+	}
+}
+
 func main() {
 	dotEnvPath := utility.GetDotEnvPath()
 	if utility.FileExists(dotEnvPath) {
@@ -58,12 +255,30 @@ func main() {
 
 	databaseFilename := getDatabaseFilename()
 
+	// web servers may pass arguments to CGI scripts so they are ignored in CGI context
+	command := "cgi"
+	if os.Getenv("GATEWAY_INTERFACE") == "" && len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	if command == "prune" {
+		prune(databaseFilename, os.Args[2:])
+		return
+	}
+
 	h, err := handler.NewSqliteHandler(databaseFilename)
 	if err != nil {
 		log.Fatalf("failed to create sqlite handler: %s", err)
 	}
-	http.HandleFunc("GET /", h.HandleGetFeed)
-	if err := cgi.Serve(nil); err != nil {
-		log.Fatalf("failed to serve cgi request: %s", err)
+
+	switch command {
+	case "cgi":
+		serveCGI(h)
+	case "serve":
+		serveHTTP(h, os.Args[2:])
+	case "fcgi":
+		serveFastCGI(h, os.Args[2:])
+	default:
+		log.Fatalf("unknown command %s, expected cgi, serve, fcgi or prune", command)
 	}
 }
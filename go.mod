@@ -2,12 +2,15 @@ module github.com/lattots/bhproxy
 
 go 1.23.4
 
-require modernc.org/sqlite v1.35.0
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/joho/godotenv v1.5.1
+	modernc.org/sqlite v1.35.0
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
@@ -2,13 +2,36 @@ package db
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
 )
 
+// busyTimeout is how long a connection waits for other processes to finish writing
+const busyTimeout = 5000 * time.Millisecond
+
+func init() {
+	// lower() of SQLite folds only ASCII letters, e.g. Finnish captions need unicode_lower()
+	sqlite.MustRegisterDeterministicScalarFunction("unicode_lower", 1, unicodeLower)
+}
+
+// unicodeLower is SQL function unicode_lower(text) which lower cases all letters like strings.ToLower
+func unicodeLower(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	switch value := args[0].(type) {
+	case string:
+		return strings.ToLower(value), nil
+	case []byte:
+		return strings.ToLower(string(value)), nil
+	default:
+		return value, nil
+	}
+}
+
 func OpenSqliteDB(filename string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", filename)
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", filename, busyTimeout.Milliseconds()))
 	if err != nil {
 		return nil, fmt.Errorf("error opening sqlite database: %w", err)
 	}
@@ -34,6 +57,15 @@ func InitSqliteDB(db *sql.DB) error {
 		return fmt.Errorf("error creating feeds table: %w", err)
 	}
 
+	err = addMissingColumns(db, "feeds", []column{
+		{"upstream_etag", "TEXT NOT NULL DEFAULT ''"},
+		{"upstream_last_modified", "TEXT NOT NULL DEFAULT ''"},
+		{"upstream_post_count", "INT NOT NULL DEFAULT 0"},
+	})
+	if err != nil {
+		return fmt.Errorf("error migrating feeds table: %w", err)
+	}
+
 	query = `CREATE TABLE IF NOT EXISTS posts
 		(post_id TEXT PRIMARY KEY,
 		feed_id TEXT,
@@ -50,5 +82,123 @@ func InitSqliteDB(db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("error creating posts table: %w", err)
 	}
+
+	err = addMissingColumns(db, "posts", []column{
+		{"media_medium_url", "TEXT NOT NULL DEFAULT ''"},
+		{"media_medium_height", "INT NOT NULL DEFAULT 0"},
+		{"media_medium_width", "INT NOT NULL DEFAULT 0"},
+		{"media_large_url", "TEXT NOT NULL DEFAULT ''"},
+		{"media_large_height", "INT NOT NULL DEFAULT 0"},
+		{"media_large_width", "INT NOT NULL DEFAULT 0"},
+		{"video_url", "TEXT NOT NULL DEFAULT ''"},
+		{"thumbnail_url", "TEXT NOT NULL DEFAULT ''"},
+	})
+	if err != nil {
+		return fmt.Errorf("error migrating posts table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS post_children
+		(child_id TEXT PRIMARY KEY,
+		post_id TEXT,
+		position INT,
+		media_type TEXT,
+		media_small_url TEXT,
+		media_small_height INT,
+		media_small_width INT,
+		media_medium_url TEXT,
+		media_medium_height INT,
+		media_medium_width INT,
+		media_large_url TEXT,
+		media_large_height INT,
+		media_large_width INT,
+		video_url TEXT,
+		thumbnail_url TEXT)`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error creating post_children table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS locks
+		(name TEXT PRIMARY KEY,
+		holder TEXT,
+		expires_at INT)`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error creating locks table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS circuit_breakers
+		(name TEXT PRIMARY KEY,
+		failures INT,
+		open_until INT)`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error creating circuit_breakers table: %w", err)
+	}
+
+	// compressed responses keyed by entity tag, so that responses with different limits or
+	// formats of the same feed don't replace each other. Only responses of the latest
+	// version of each feed are kept.
+	query = `CREATE TABLE IF NOT EXISTS encoded_responses
+		(etag TEXT,
+		encoding TEXT,
+		feed_id TEXT,
+		fetched_at INT,
+		body BLOB,
+		PRIMARY KEY (etag, encoding))`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error creating encoded_responses table: %w", err)
+	}
+	return nil
+}
+
+type column struct {
+	name       string
+	definition string
+}
+
+// tableColumns returns the column names of the table. The map is empty if the table doesn't exist.
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns of table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning column name: %w", err)
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating columns of table %s: %w", table, err)
+	}
+	return columns, nil
+}
+
+// addMissingColumns adds columns introduced after the table was first created
+func addMissingColumns(db *sql.DB, table string, columns []column) error {
+	existing, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range columns {
+		if existing[c.name] {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.definition))
+		if err != nil {
+			return fmt.Errorf("error adding column %s to table %s: %w", c.name, table, err)
+		}
+	}
 	return nil
 }
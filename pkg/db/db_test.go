@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 )
@@ -70,3 +71,84 @@ func TestInitSqliteDB(t *testing.T) {
 		t.Errorf("InitSqliteDB returned an error when tables already exist: %v", err)
 	}
 }
+
+func TestInitSqliteDBMigratesPosts(t *testing.T) {
+	tempDB := "test.db"
+	defer os.Remove(tempDB)
+
+	db, err := OpenSqliteDB(tempDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE posts (post_id TEXT PRIMARY KEY, feed_id TEXT, media_small_url TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO posts (post_id, feed_id) VALUES (?, ?)", "oldpost", "testfeed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitSqliteDB(db)
+	if err != nil {
+		t.Fatalf("InitSqliteDB returned an error when migrating posts table: %v", err)
+	}
+
+	var mediumURL string
+	var largeWidth int
+	err = db.QueryRow("SELECT media_medium_url, media_large_width FROM posts WHERE post_id = ?", "oldpost").Scan(&mediumURL, &largeWidth)
+	if err != nil {
+		t.Errorf("Could not query migrated columns: %v", err)
+	}
+	if mediumURL != "" || largeWidth != 0 {
+		t.Errorf("Expected migrated columns to have default values, got %q and %d", mediumURL, largeWidth)
+	}
+}
+
+func TestInitSqliteDBEncodedResponses(t *testing.T) {
+	tempDB := "test.db"
+	defer os.Remove(tempDB)
+
+	db, err := OpenSqliteDB(tempDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = InitSqliteDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// responses of the same feed with different limits have different entity tags
+	for _, etag := range []string{`"abc"`, `"def"`} {
+		_, err = db.Exec(`INSERT INTO encoded_responses (etag, encoding, feed_id, fetched_at, body) VALUES (?, ?, ?, ?, ?)`,
+			etag, "gzip", "testfeed", 1, []byte("body"))
+		if err != nil {
+			t.Errorf("Could not insert response %s: %v", etag, err)
+		}
+	}
+}
+
+func TestUnicodeLower(t *testing.T) {
+	tempDB := "test.db"
+	defer os.Remove(tempDB)
+
+	db, err := OpenSqliteDB(tempDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var lowered string
+	var null sql.NullString
+	err = db.QueryRow("SELECT unicode_lower(?), unicode_lower(NULL)", "#KESÄ Äiti").Scan(&lowered, &null)
+	if err != nil {
+		t.Fatalf("Could not call unicode_lower: %v", err)
+	}
+	if lowered != "#kesä äiti" || null.Valid {
+		t.Errorf("Expected unicode letters to be lower cased, got %q and %v", lowered, null)
+	}
+}
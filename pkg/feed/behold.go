@@ -1,10 +1,12 @@
 package feed
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -12,8 +14,11 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func (f *Feed) getFromBehold() error {
-	resp, err := fetchFeedResponse(http.DefaultClient, f.ID)
+func (f *Feed) getFromBehold(client *Client) error {
+	resp, err := client.fetchFeedResponse(f.ID, f.upstreamETag, f.upstreamLastModified)
+	if errors.Is(err, errNotModified) {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("error fetching feed from Behold: %w", err)
 	}
@@ -24,25 +29,36 @@ func (f *Feed) getFromBehold() error {
 	return nil
 }
 
-const apiBaseUrl = "https://feeds.behold.so/"
-
 // ErrFeedNotExists indicates that feed doesn't exist even in Beholds database
 var ErrFeedNotExists = errors.New("feed not found")
 
-func fetchFeedResponse(client HTTPClient, id string) (feedResponse, error) {
-	url := apiBaseUrl + id
+// errNotModified means that the feed has not changed since it was last fetched
+var errNotModified = errors.New("feed not modified")
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return feedResponse{}, fmt.Errorf("error creating request: %w", err)
+// fetchFeedResponse fetches the feed from Behold. If ETag or Last-Modified of the
+// previous response are given, the request is made conditional and errNotModified is
+// returned when the feed has not changed.
+func (c *Client) fetchFeedResponse(id, etag, lastModified string) (feedResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.FeedTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := c.get(ctx, c.HTTPClient, c.BaseURL+url.PathEscape(id), header)
 	if err != nil {
 		return feedResponse{}, fmt.Errorf("error fetching feed %s: %w", id, err)
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return feedResponse{}, errNotModified
+	}
 	if resp.StatusCode == http.StatusNotFound {
 		return feedResponse{}, ErrFeedNotExists
 	}
@@ -51,6 +67,8 @@ func fetchFeedResponse(client HTTPClient, id string) (feedResponse, error) {
 	}
 	var feed feedResponse
 	feed.ID = id
+	feed.etag = resp.Header.Get("ETag")
+	feed.lastModified = resp.Header.Get("Last-Modified")
 	err = json.NewDecoder(resp.Body).Decode(&feed)
 	if err != nil {
 		return feedResponse{}, fmt.Errorf("error parsing feed %s: %w", id, err)
@@ -66,6 +84,8 @@ func parseFeedFromResponse(feedResponse feedResponse, feed *Feed) error {
 	feed.Website = feedResponse.Website
 	feed.FollowersCount = feedResponse.FollowersCount
 	feed.FollowsCount = feedResponse.FollowsCount
+	feed.upstreamETag = feedResponse.etag
+	feed.upstreamLastModified = feedResponse.lastModified
 	feed.Posts = make([]Post, 0)
 	for _, post := range feedResponse.Posts {
 		layout := "2006-01-02T15:04:05Z0700" // correct time format for +0000 timezone
@@ -78,21 +98,67 @@ func parseFeedFromResponse(feedResponse feedResponse, feed *Feed) error {
 
 		fmt.Println("Parsed time:", parsedTime)
 		feed.Posts = append(feed.Posts, Post{
-			ID:                    post.ID,
-			feedID:                feed.ID,
-			Permalink:             post.Permalink,
-			Timestamp:             parsedTime,
-			MediaType:             post.MediaType,
-			mediaSmallExternalURL: post.Sizes.Small.MediaURL,
-			MediaSmallHeight:      post.Sizes.Small.Height,
-			MediaSmallWidth:       post.Sizes.Small.Width,
-			Caption:               post.Caption,
-			PrunedCaption:         post.PrunedCaption,
+			ID:            post.ID,
+			feedID:        feed.ID,
+			Permalink:     post.Permalink,
+			Timestamp:     parsedTime,
+			MediaType:     post.MediaType,
+			Sizes:         parseSizesFromResponse(post.Sizes),
+			Children:      parseChildrenFromResponse(post.Children),
+			Caption:       post.Caption,
+			PrunedCaption: post.PrunedCaption,
+
+			videoExternalURL:     videoURLFromResponse(post.MediaType, post.MediaURL),
+			thumbnailExternalURL: post.ThumbnailURL,
 		})
 	}
 	return nil
 }
 
+func parseSizesFromResponse(sizes sizesResponse) Sizes {
+	return Sizes{
+		Small: Media{
+			Width:       sizes.Small.Width,
+			Height:      sizes.Small.Height,
+			externalURL: sizes.Small.MediaURL,
+		},
+		Medium: Media{
+			Width:       sizes.Medium.Width,
+			Height:      sizes.Medium.Height,
+			externalURL: sizes.Medium.MediaURL,
+		},
+		Large: Media{
+			Width:       sizes.Large.Width,
+			Height:      sizes.Large.Height,
+			externalURL: sizes.Large.MediaURL,
+		},
+	}
+}
+
+func parseChildrenFromResponse(children []childResponse) []Child {
+	parsed := make([]Child, 0, len(children))
+	for _, child := range children {
+		parsed = append(parsed, Child{
+			ID:        child.ID,
+			MediaType: child.MediaType,
+			Sizes:     parseSizesFromResponse(child.Sizes),
+
+			videoExternalURL:     videoURLFromResponse(child.MediaType, child.MediaURL),
+			thumbnailExternalURL: child.ThumbnailURL,
+		})
+	}
+	return parsed
+}
+
+// videoURLFromResponse returns the media URL of videos. Media URL of images is
+// not needed as the images are served in sizes.
+func videoURLFromResponse(mediaType, mediaURL string) string {
+	if mediaType != "VIDEO" {
+		return ""
+	}
+	return mediaURL
+}
+
 type feedResponse struct {
 	ID             string
 	Username       string         `json:"username"`
@@ -102,16 +168,30 @@ type feedResponse struct {
 	FollowersCount int            `json:"followersCount"`
 	FollowsCount   int            `json:"followsCount"`
 	Posts          []postResponse `json:"posts"`
+
+	etag         string
+	lastModified string
 }
 
 type postResponse struct {
-	ID              string        `json:"id"`
-	TimestampString string        `json:"timestamp"`
-	Permalink       string        `json:"permalink"`
-	MediaType       string        `json:"mediaType"`
-	Sizes           sizesResponse `json:"sizes"`
-	Caption         string        `json:"caption"`
-	PrunedCaption   string        `json:"prunedCaption"`
+	ID              string          `json:"id"`
+	TimestampString string          `json:"timestamp"`
+	Permalink       string          `json:"permalink"`
+	MediaType       string          `json:"mediaType"`
+	MediaURL        string          `json:"mediaUrl"`
+	ThumbnailURL    string          `json:"thumbnailUrl"`
+	Sizes           sizesResponse   `json:"sizes"`
+	Children        []childResponse `json:"children"`
+	Caption         string          `json:"caption"`
+	PrunedCaption   string          `json:"prunedCaption"`
+}
+
+type childResponse struct {
+	ID           string        `json:"id"`
+	MediaType    string        `json:"mediaType"`
+	MediaURL     string        `json:"mediaUrl"`
+	ThumbnailURL string        `json:"thumbnailUrl"`
+	Sizes        sizesResponse `json:"sizes"`
 }
 
 type sizesResponse struct {
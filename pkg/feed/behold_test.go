@@ -3,17 +3,22 @@ package feed
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type MockHTTPClient struct {
 	Response *http.Response
 	Error    error
+	Request  *http.Request
 }
 
 func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	m.Request = req
 	return m.Response, m.Error
 }
 
@@ -23,7 +28,14 @@ func TestGetFeed(t *testing.T) {
 		ID:       "123",
 		Username: "test account name",
 		Posts: []postResponse{
-			{ID: "post1", TimestampString: sampleTime, Permalink: "link", MediaType: "photo"},
+			{
+				ID: "post1", TimestampString: sampleTime, Permalink: "link", MediaType: "photo",
+				Sizes: sizesResponse{
+					Small:  smallResponse{Width: 300, Height: 400, MediaURL: "https://example.com/small.webp"},
+					Medium: mediumResponse{Width: 600, Height: 800, MediaURL: "https://example.com/medium.webp"},
+					Large:  largeResponse{Width: 1000, Height: 1333, MediaURL: "https://example.com/large.webp"},
+				},
+			},
 		},
 	}
 
@@ -37,11 +49,19 @@ func TestGetFeed(t *testing.T) {
 		Error: nil,
 	}
 
-	feedResponse, err := fetchFeedResponse(mockClient, "123")
+	client := &Client{BaseURL: "http://behold.test/", FeedTimeout: time.Second, UserAgent: "bhproxy-test", HTTPClient: mockClient}
+	feedResponse, err := client.fetchFeedResponse("123", "", "")
 	if err != nil {
 		t.Errorf("fetchFeedResponse returned an error: %v", err)
 	}
 
+	if mockClient.Request.URL.String() != "http://behold.test/123" {
+		t.Errorf("Expected request to http://behold.test/123, got %s", mockClient.Request.URL)
+	}
+	if mockClient.Request.Header.Get("User-Agent") != "bhproxy-test" {
+		t.Errorf("Expected User-Agent bhproxy-test, got %s", mockClient.Request.Header.Get("User-Agent"))
+	}
+
 	if feedResponse.ID != "123" {
 		t.Errorf("Expected feed ID to be 123, got %s", feedResponse.ID)
 	}
@@ -64,7 +84,210 @@ func TestGetFeed(t *testing.T) {
 	if len(feed.Posts) != 1 {
 		t.Errorf("Expected 1 post, got %d", len(feed.Posts))
 	}
+	if feed.Posts[0].Sizes.Medium.externalURL != "https://example.com/medium.webp" || feed.Posts[0].Sizes.Large.Width != 1000 {
+		t.Errorf("Expected medium and large sizes to be parsed, got %+v", feed.Posts[0].Sizes)
+	}
 	if feed.Posts[0].Timestamp.String() == "" {
 		t.Errorf("Expected timestamp to be set, got %s", feed.Posts[0].Timestamp)
 	}
 }
+
+func TestParseCarouselAndVideoPosts(t *testing.T) {
+	sampleTime := "2025-01-29T18:34:09+0000"
+	response := feedResponse{
+		ID: "123",
+		Posts: []postResponse{
+			{
+				ID: "video1", TimestampString: sampleTime, MediaType: "VIDEO",
+				MediaURL: "https://example.com/video1.mp4", ThumbnailURL: "https://example.com/video1.jpg",
+			},
+			{
+				ID: "carousel1", TimestampString: sampleTime, MediaType: "CAROUSEL_ALBUM",
+				MediaURL: "https://example.com/cover.jpg",
+				Children: []childResponse{
+					{ID: "child1", MediaType: "IMAGE", MediaURL: "https://example.com/child1.jpg"},
+					{ID: "child2", MediaType: "VIDEO", MediaURL: "https://example.com/child2.mp4", ThumbnailURL: "https://example.com/child2.jpg"},
+				},
+			},
+		},
+	}
+
+	feed := &Feed{}
+	err := parseFeedFromResponse(response, feed)
+	if err != nil {
+		t.Fatalf("parseFeedFromResponse returned an error: %v", err)
+	}
+
+	video := feed.Posts[0]
+	if video.videoExternalURL != "https://example.com/video1.mp4" || video.thumbnailExternalURL != "https://example.com/video1.jpg" {
+		t.Errorf("Expected video and thumbnail urls to be parsed, got %s and %s", video.videoExternalURL, video.thumbnailExternalURL)
+	}
+
+	carousel := feed.Posts[1]
+	if carousel.videoExternalURL != "" {
+		t.Errorf("Expected carousel to have no video url, got %s", carousel.videoExternalURL)
+	}
+	if len(carousel.Children) != 2 {
+		t.Fatalf("Expected 2 children, got %d", len(carousel.Children))
+	}
+	if carousel.Children[0].videoExternalURL != "" {
+		t.Errorf("Expected image child to have no video url, got %s", carousel.Children[0].videoExternalURL)
+	}
+	if carousel.Children[1].videoExternalURL != "https://example.com/child2.mp4" {
+		t.Errorf("Expected video child to have video url, got %s", carousel.Children[1].videoExternalURL)
+	}
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	t.Setenv("BHP_BEHOLD_BASE_URL", "http://localhost:9000/feeds")
+	t.Setenv("BHP_FEED_TIMEOUT", "3s")
+	t.Setenv("BHP_USER_AGENT", "")
+	t.Setenv("BHP_HTTP_PROXY", "http://proxy.example.com:3128")
+
+	client, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewClientFromEnv returned an error: %v", err)
+	}
+	if client.BaseURL != "http://localhost:9000/feeds/" {
+		t.Errorf("Expected base url with trailing slash, got %s", client.BaseURL)
+	}
+	if client.FeedTimeout != 3*time.Second {
+		t.Errorf("Expected feed timeout 3s, got %s", client.FeedTimeout)
+	}
+	if client.UserAgent != defaultUserAgent {
+		t.Errorf("Expected default User-Agent, got %s", client.UserAgent)
+	}
+
+	t.Setenv("BHP_FEED_TIMEOUT", "soon")
+	_, err = NewClientFromEnv()
+	if err == nil {
+		t.Errorf("Expected error for invalid feed timeout")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"username": "retried"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{
+		BaseURL:        server.URL + "/",
+		FeedTimeout:    5 * time.Second,
+		HTTPClient:     server.Client(),
+		MaxRetries:     2,
+		RetryBaseDelay: 10 * time.Millisecond,
+	}
+
+	response, err := client.fetchFeedResponse("123", "", "")
+	if err != nil {
+		t.Fatalf("fetchFeedResponse returned an error: %v", err)
+	}
+	if response.Username != "retried" || requests != 3 {
+		t.Errorf("Expected response after 3 requests, got %q after %d requests", response.Username, requests)
+	}
+
+	requests = 0
+	client.MaxRetries = 1
+	_, err = client.fetchFeedResponse("123", "", "")
+	if err == nil {
+		t.Errorf("Expected error when retries run out")
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay, ok := parseRetryAfter("3"); !ok || delay != 3*time.Second {
+		t.Errorf("Expected 3s delay, got %s", delay)
+	}
+	if delay, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); !ok || delay <= 0 || delay > time.Minute {
+		t.Errorf("Expected delay up to a minute, got %s", delay)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Errorf("Expected invalid Retry-After to be ignored")
+	}
+}
+
+func TestConditionalFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 29 Jan 2025 18:34:09 GMT")
+		w.Write([]byte(`{"username": "conditional"}`))
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL + "/", FeedTimeout: 5 * time.Second, HTTPClient: server.Client()}
+
+	response, err := client.fetchFeedResponse("123", "", "")
+	if err != nil {
+		t.Fatalf("fetchFeedResponse returned an error: %v", err)
+	}
+	if response.etag != `"v1"` || response.lastModified != "Wed, 29 Jan 2025 18:34:09 GMT" {
+		t.Errorf("Expected validators to be stored, got %q and %q", response.etag, response.lastModified)
+	}
+
+	_, err = client.fetchFeedResponse("123", `"v1"`, "")
+	if !errors.Is(err, errNotModified) {
+		t.Errorf("Expected errNotModified, got %v", err)
+	}
+
+	database := newTestDB(t)
+	insertTestFeed(t, database, "123", time.Now().UTC().Add(-48*time.Hour))
+	insertTestPosts(t, database, "123", 3)
+	_, err = database.Exec(`UPDATE feeds SET upstream_etag = ?, upstream_post_count = ? WHERE feed_id = ?`, `"v1"`, defaultPostCount, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed := &Feed{ID: "123"}
+	err = feed.fetchOrCreateFeed(database, client, defaultPostCount)
+	if err != nil {
+		t.Fatalf("fetchOrCreateFeed returned an error: %v", err)
+	}
+	if len(feed.Posts) != 3 || feed.Username != "user" {
+		t.Errorf("Expected cached feed with 3 posts, got %d posts of %s", len(feed.Posts), feed.Username)
+	}
+	if time.Since(feed.FetchedAt) > time.Minute {
+		t.Errorf("Expected unmodified feed to be marked fetched now, got %s", feed.FetchedAt)
+	}
+	if !feed.IsRefreshed() {
+		t.Errorf("Expected unmodified feed to be reported refreshed")
+	}
+
+	feed = &Feed{ID: "123"}
+	err = feed.fetchOrCreateFeed(database, client, defaultPostCount)
+	if err != nil || feed.IsRefreshed() {
+		t.Errorf("Expected feed to be served from database without refresh, got %v", err)
+	}
+
+	// stored posts were trimmed to the previous post count, so all posts are fetched again
+	t.Setenv("BHP_POST_COUNT", "10")
+	_, err = database.Exec(`UPDATE feeds SET last_fetched = ? WHERE feed_id = ?`, time.Now().UTC().Add(-48*time.Hour), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed = &Feed{ID: "123"}
+	err = feed.fetchOrCreateFeed(database, client, 10)
+	if err != nil {
+		t.Fatalf("fetchOrCreateFeed returned an error: %v", err)
+	}
+	if feed.Username != "conditional" || !feed.IsRefreshed() {
+		t.Errorf("Expected feed to be fetched without validators after raising post count, got %s", feed.Username)
+	}
+}
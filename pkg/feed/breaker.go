@@ -0,0 +1,94 @@
+package feed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// beholdBreaker is the name of the circuit breaker guarding feed requests to Behold
+	beholdBreaker = "behold"
+	// defaultBreakerThreshold is used when BHP_BREAKER_THRESHOLD is not set
+	defaultBreakerThreshold = 5
+	// defaultBreakerCooldown is used when BHP_BREAKER_COOLDOWN is not set
+	defaultBreakerCooldown = 5 * time.Minute
+)
+
+// ErrCircuitOpen means that Behold has failed repeatedly and is not contacted until
+// the cooldown has passed
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// getBreakerSettings returns how many consecutive failures open the circuit breaker
+// and how long it stays open
+func getBreakerSettings() (int, time.Duration, error) {
+	threshold := defaultBreakerThreshold
+	if thresholdStr := os.Getenv("BHP_BREAKER_THRESHOLD"); thresholdStr != "" {
+		var err error
+		threshold, err = strconv.Atoi(thresholdStr)
+		if err != nil || threshold < 1 {
+			return 0, 0, fmt.Errorf("invalid BHP_BREAKER_THRESHOLD %s", thresholdStr)
+		}
+	}
+
+	cooldown := defaultBreakerCooldown
+	if cooldownStr := os.Getenv("BHP_BREAKER_COOLDOWN"); cooldownStr != "" {
+		var err error
+		cooldown, err = time.ParseDuration(cooldownStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid BHP_BREAKER_COOLDOWN %s: %w", cooldownStr, err)
+		}
+	}
+
+	return threshold, cooldown, nil
+}
+
+// withCircuitBreaker runs call unless the named breaker is open. The breaker state is
+// stored in the database as CGI processes share no memory. Missing and unmodified
+// feeds are not counted as failures as Behold did answer.
+func withCircuitBreaker(db *sql.DB, name string, call func() error) error {
+	threshold, cooldown, err := getBreakerSettings()
+	if err != nil {
+		return err
+	}
+
+	var openUntil int64
+	err = db.QueryRow(`SELECT open_until FROM circuit_breakers WHERE name = ?`, name).Scan(&openUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read circuit breaker %s: %w", name, err)
+	}
+	if time.Now().UnixNano() < openUntil {
+		return ErrCircuitOpen
+	}
+
+	callErr := call()
+	if callErr == nil || errors.Is(callErr, ErrFeedNotExists) || errors.Is(callErr, errNotModified) {
+		_, err = db.Exec(`DELETE FROM circuit_breakers WHERE name = ?`, name)
+		if err != nil {
+			log.Printf("failed to reset circuit breaker %s: %s", name, err)
+		}
+		return callErr
+	}
+
+	// breaker opens once failures reach the threshold and reopens on each further
+	// failure until a call succeeds
+	_, err = db.Exec(
+		`INSERT INTO circuit_breakers (name, failures, open_until) VALUES (?, 1, 0)
+		ON CONFLICT(name) DO UPDATE SET failures = failures + 1;`,
+		name,
+	)
+	if err == nil {
+		_, err = db.Exec(
+			`UPDATE circuit_breakers SET open_until = ? WHERE name = ? AND failures >= ?;`,
+			time.Now().Add(cooldown).UnixNano(), name, threshold,
+		)
+	}
+	if err != nil {
+		log.Printf("failed to record failure to circuit breaker %s: %s", name, err)
+	}
+	return callErr
+}
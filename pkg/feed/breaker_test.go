@@ -0,0 +1,52 @@
+package feed
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	database := newTestDB(t)
+	t.Setenv("BHP_BREAKER_THRESHOLD", "2")
+	t.Setenv("BHP_BREAKER_COOLDOWN", "1h")
+
+	calls := 0
+	failing := func() error {
+		calls++
+		return errors.New("upstream down")
+	}
+
+	for range 2 {
+		err := withCircuitBreaker(database, beholdBreaker, failing)
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected call error before threshold, got %v", err)
+		}
+	}
+
+	err := withCircuitBreaker(database, beholdBreaker, failing)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen after threshold, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected no calls while circuit is open, got %d calls", calls)
+	}
+
+	err = withCircuitBreaker(database, "other", func() error { return nil })
+	if err != nil {
+		t.Errorf("expected other breaker to stay closed, got %v", err)
+	}
+
+	// cooldown has passed
+	_, err = database.Exec(`UPDATE circuit_breakers SET open_until = 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = withCircuitBreaker(database, beholdBreaker, func() error { return ErrFeedNotExists })
+	if !errors.Is(err, ErrFeedNotExists) {
+		t.Errorf("expected call to be made after cooldown, got %v", err)
+	}
+	err = withCircuitBreaker(database, beholdBreaker, failing)
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected missing feed to reset failures, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package feed
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// insertChildren replaces the carousel children of the post in the database
+func insertChildren(tx *sql.Tx, post Post) error {
+	_, err := tx.Exec(`DELETE FROM post_children WHERE post_id = ?`, post.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete old children: %w", err)
+	}
+
+	for i, child := range post.Children {
+		_, err = tx.Exec(
+			`INSERT OR REPLACE INTO post_children
+			(child_id, post_id, position, media_type,
+			media_small_url, media_small_height, media_small_width,
+			media_medium_url, media_medium_height, media_medium_width,
+			media_large_url, media_large_height, media_large_width,
+			video_url, thumbnail_url)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			child.ID, post.ID, i, child.MediaType,
+			child.Sizes.Small.externalURL, child.Sizes.Small.Height, child.Sizes.Small.Width,
+			child.Sizes.Medium.externalURL, child.Sizes.Medium.Height, child.Sizes.Medium.Width,
+			child.Sizes.Large.externalURL, child.Sizes.Large.Height, child.Sizes.Large.Width,
+			child.videoExternalURL, child.thumbnailExternalURL,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert child %s: %w", child.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// queryChildren fetches carousel children of the feed posts from database
+func queryChildren(db *sql.DB, feed *Feed) error {
+	rows, err := db.Query(
+		`SELECT
+        child_id,
+        post_children.post_id,
+        post_children.media_type,
+        post_children.media_small_url,
+        post_children.media_small_height,
+        post_children.media_small_width,
+        post_children.media_medium_url,
+        post_children.media_medium_height,
+        post_children.media_medium_width,
+        post_children.media_large_url,
+        post_children.media_large_height,
+        post_children.media_large_width,
+        post_children.video_url,
+        post_children.thumbnail_url
+    FROM post_children
+    INNER JOIN posts ON post_children.post_id = posts.post_id
+    WHERE posts.feed_id = ?
+    ORDER BY post_children.position;`,
+		feed.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying children: %w", err)
+	}
+	defer rows.Close()
+
+	postIndexes := make(map[string]int, len(feed.Posts))
+	for i, post := range feed.Posts {
+		postIndexes[post.ID] = i
+	}
+
+	for rows.Next() {
+		var postID string
+		child := Child{}
+		err := rows.Scan(
+			&child.ID,
+			&postID,
+			&child.MediaType,
+			&child.Sizes.Small.externalURL,
+			&child.Sizes.Small.Height,
+			&child.Sizes.Small.Width,
+			&child.Sizes.Medium.externalURL,
+			&child.Sizes.Medium.Height,
+			&child.Sizes.Medium.Width,
+			&child.Sizes.Large.externalURL,
+			&child.Sizes.Large.Height,
+			&child.Sizes.Large.Width,
+			&child.videoExternalURL,
+			&child.thumbnailExternalURL,
+		)
+		if err != nil {
+			return fmt.Errorf("error scanning child row: %w", err)
+		}
+
+		// children of posts left out by the limit are skipped
+		i, found := postIndexes[postID]
+		if !found {
+			continue
+		}
+		feed.Posts[i].Children = append(feed.Posts[i].Children, child)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating child rows: %w", err)
+	}
+
+	return nil
+}
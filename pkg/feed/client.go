@@ -0,0 +1,190 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultBaseURL is used when BHP_BEHOLD_BASE_URL is not set
+	defaultBaseURL = "https://feeds.behold.so/"
+	// defaultFeedTimeout is used when BHP_FEED_TIMEOUT is not set
+	defaultFeedTimeout = 15 * time.Second
+	// defaultUserAgent is used when BHP_USER_AGENT is not set
+	defaultUserAgent = "bhproxy"
+	// defaultMaxRetries is used when BHP_RETRY_MAX is not set
+	defaultMaxRetries = 2
+	// defaultRetryBaseDelay is used when BHP_RETRY_BASE_DELAY is not set
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay caps backoff and Retry-After delays between retries
+	retryMaxDelay = 10 * time.Second
+)
+
+// Client fetches feeds and their media from Behold
+type Client struct {
+	// BaseURL is prefixed to feed IDs to get the feed URL
+	BaseURL string
+	// FeedTimeout limits fetching a single feed. Media downloads are limited by BHP_IMAGE_TIMEOUT.
+	FeedTimeout time.Duration
+	UserAgent   string
+	HTTPClient  HTTPClient
+	// MediaHTTPClient downloads images and videos. It must refuse connections to internal addresses.
+	MediaHTTPClient HTTPClient
+	// MediaHosts lists host suffixes media may be downloaded from
+	MediaHosts []string
+	// MaxRetries is how many times failed requests are retried
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry, doubled for each further retry
+	RetryBaseDelay time.Duration
+}
+
+// NewClientFromEnv creates a client configured with environment variables
+func NewClientFromEnv() (*Client, error) {
+	client := &Client{
+		BaseURL:        defaultBaseURL,
+		FeedTimeout:    defaultFeedTimeout,
+		UserAgent:      defaultUserAgent,
+		MaxRetries:     defaultMaxRetries,
+		RetryBaseDelay: defaultRetryBaseDelay,
+		MediaHosts:     getMediaHosts(),
+	}
+
+	if baseURL := os.Getenv("BHP_BEHOLD_BASE_URL"); baseURL != "" {
+		// feed ID is appended to the base URL
+		client.BaseURL = strings.TrimSuffix(baseURL, "/") + "/"
+	}
+
+	if timeoutStr := os.Getenv("BHP_FEED_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BHP_FEED_TIMEOUT %s: %w", timeoutStr, err)
+		}
+		client.FeedTimeout = timeout
+	}
+
+	if maxRetriesStr := os.Getenv("BHP_RETRY_MAX"); maxRetriesStr != "" {
+		maxRetries, err := strconv.Atoi(maxRetriesStr)
+		if err != nil || maxRetries < 0 {
+			return nil, fmt.Errorf("invalid BHP_RETRY_MAX %s", maxRetriesStr)
+		}
+		client.MaxRetries = maxRetries
+	}
+
+	if delayStr := os.Getenv("BHP_RETRY_BASE_DELAY"); delayStr != "" {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BHP_RETRY_BASE_DELAY %s: %w", delayStr, err)
+		}
+		client.RetryBaseDelay = delay
+	}
+
+	if userAgent := os.Getenv("BHP_USER_AGENT"); userAgent != "" {
+		client.UserAgent = userAgent
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyStr := os.Getenv("BHP_HTTP_PROXY"); proxyStr != "" {
+		proxyURL, err := url.Parse(proxyStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BHP_HTTP_PROXY %s: %w", proxyStr, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client.HTTPClient = &http.Client{Transport: transport}
+
+	// internal addresses can be allowed for testing against local mock services
+	allowPrivate := false
+	if allowPrivateStr := os.Getenv("BHP_MEDIA_ALLOW_PRIVATE_IPS"); allowPrivateStr != "" {
+		var err error
+		allowPrivate, err = strconv.ParseBool(allowPrivateStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BHP_MEDIA_ALLOW_PRIVATE_IPS %s: %w", allowPrivateStr, err)
+		}
+	}
+	client.MediaHTTPClient = newMediaHTTPClient(transport, client.MediaHosts, allowPrivate)
+
+	return client, nil
+}
+
+// get sends GET request to url with httpClient using the given headers and the client User-Agent.
+// Network errors, 5xx and 429 responses are retried with jittered exponential backoff.
+func (c *Client) get(ctx context.Context, httpClient HTTPClient, url string, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("User-Agent", c.UserAgent)
+
+		resp, err := httpClient.Do(req)
+		if attempt >= c.MaxRetries || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := c.retryDelay(attempt, resp)
+		if err != nil {
+			log.Printf("retrying %s in %s after error: %s", url, delay, err)
+		} else {
+			log.Printf("retrying %s in %s after status %d", url, delay, resp.StatusCode)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up retrying %s: %w", url, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// shouldRetry reports whether the request failed for a possibly transient reason
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// refused media downloads would be refused again
+		return !errors.Is(err, ErrMediaNotAllowed)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryDelay returns how long to wait before the next attempt. Delay requested by
+// the server with Retry-After is honored up to retryMaxDelay.
+func (c *Client) retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(delay, retryMaxDelay)
+		}
+	}
+
+	backoff := min(c.RetryBaseDelay<<attempt, retryMaxDelay)
+	if backoff <= 0 {
+		return 0
+	}
+	// half of the backoff is randomized so that processes don't retry in sync
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// parseRetryAfter parses Retry-After header given either in seconds or as HTTP date
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
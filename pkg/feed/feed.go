@@ -4,27 +4,37 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/lattots/bhproxy/pkg/utility"
 )
 
 type Feed struct {
-	ID                string `json:"id"`
-	Username          string `json:"username"`
-	Biography         string `json:"biography"`
-	ProfilePictureUrl string `json:"profilePictureUrl"`
-	Website           string `json:"website"`
-	FollowersCount    int    `json:"followersCount"`
-	FollowsCount      int    `json:"followsCount"`
-	Posts             []Post `json:"posts"`
-
-	lastFetched time.Time
+	ID                string    `json:"id"`
+	Username          string    `json:"username"`
+	Biography         string    `json:"biography"`
+	ProfilePictureUrl string    `json:"profilePictureUrl"`
+	Website           string    `json:"website"`
+	FollowersCount    int       `json:"followersCount"`
+	FollowsCount      int       `json:"followsCount"`
+	Posts             []Post    `json:"posts"`
+	FetchedAt         time.Time `json:"fetchedAt"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+
+	stale                bool
+	refreshed            bool
+	temporaryMedia       bool
+	filter               PostFilter
+	upstreamETag         string
+	upstreamLastModified string
+	// upstreamPostCount is the post count the stored posts were trimmed to
+	upstreamPostCount int
 }
 
 type Post struct {
@@ -36,32 +46,101 @@ type Post struct {
 	MediaSmallUrl    string    `json:"mediaSmallUrl"`
 	MediaSmallHeight int       `json:"mediaSmallHeight"`
 	MediaSmallWidth  int       `json:"mediaSmallWidth"`
+	Sizes            Sizes     `json:"sizes"`
+	VideoUrl         string    `json:"videoUrl"`
+	ThumbnailUrl     string    `json:"thumbnailUrl"`
+	Children         []Child   `json:"children"`
 	Caption          string    `json:"caption"`
 	PrunedCaption    string    `json:"prunedCaption"`
 
-	mediaSmallExternalURL string
+	videoExternalURL     string
+	thumbnailExternalURL string
+}
+
+// Child is a single image or video of a carousel album post
+type Child struct {
+	ID           string `json:"id"`
+	MediaType    string `json:"mediaType"`
+	Sizes        Sizes  `json:"sizes"`
+	VideoUrl     string `json:"videoUrl"`
+	ThumbnailUrl string `json:"thumbnailUrl"`
+
+	videoExternalURL     string
+	thumbnailExternalURL string
+}
+
+// Sizes holds the image of a post in all sizes provided by Behold
+type Sizes struct {
+	Small  Media `json:"small"`
+	Medium Media `json:"medium"`
+	Large  Media `json:"large"`
+}
+
+// Media is a single image stored in the image directory
+type Media struct {
+	Url    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	externalURL string
+}
+
+// validFeedID matches Behold feed IDs, e.g. JYK0zcST7PconDbzq1GL
+var validFeedID = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+// IsValidFeedID reports whether id has the format of a Behold feed ID
+func IsValidFeedID(id string) bool {
+	return validFeedID.MatchString(id)
+}
+
+// ErrInvalidFeedID means that the given feed ID is malformed
+var ErrInvalidFeedID = errors.New("invalid feed id")
+
+// ErrFeedNotAllowed means that the feed ID is not in BHP_ALLOWED_FEED_IDS
+var ErrFeedNotAllowed = errors.New("feed not allowed")
+
+// ErrUpstreamUnavailable means that the feed could not be fetched from Behold and
+// there was no cached copy to serve instead
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// GetFeedWithID returns feed with its most recent posts. At most limit posts are
+// returned. If limit is zero or exceeds the post count configured for the feed,
+// the configured post count is used.
+func GetFeedWithID(db *sql.DB, client *Client, id string, limit int) (*Feed, error) {
+	return GetFilteredFeed(db, client, id, limit, PostFilter{}, AllMedia)
 }
 
-func GetFeedWithID(db *sql.DB, id string) (*Feed, error) {
+// GetFilteredFeed returns feed with its most recent posts selected by filter. Only the
+// BHP_POST_COUNT posts stored in the database are filtered, older posts are not fetched.
+// Only the media files of the selection are downloaded and have URLs.
+func GetFilteredFeed(db *sql.DB, client *Client, id string, limit int, filter PostFilter, media MediaSelection) (*Feed, error) {
+	if !IsValidFeedID(id) {
+		return nil, ErrInvalidFeedID
+	}
 	if !isAllowedFeedId(id) {
-		return nil, fmt.Errorf("given feed id %s is not in the whitelist", id)
+		return nil, fmt.Errorf("given feed id %s is not in the whitelist: %w", id, ErrFeedNotAllowed)
 	}
 
-	feed := &Feed{ID: id}
+	postCount, err := getPostCount(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post count: %w", err)
+	}
+	if limit <= 0 || limit > postCount {
+		limit = postCount
+	}
+
+	feed := &Feed{ID: id, filter: filter}
 
-	err := feed.fetchOrCreateFeed(db)
+	err = feed.fetchOrCreateFeed(db, client, limit)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching feed: %w", err)
 	}
 
-	err = feed.populatePostImages(db)
+	err = feed.populatePostImages(db, client, media)
 	if err != nil {
 		return nil, fmt.Errorf("error populating post images: %w", err)
 	}
 
-	// server is left cleaning up deprecated posts on its own
-	go feed.removeDeprecatedPosts(db)
-
 	return feed, nil
 }
 
@@ -78,53 +157,255 @@ func isAllowedFeedId(feedID string) bool {
 	return slices.Contains(allowedFeedIds, feedID)
 }
 
-func (f *Feed) fetchOrCreateFeed(db *sql.DB) error {
-	queryRes, err := queryFeed(db, f.ID)
+const (
+	// defaultPostCount is used when BHP_POST_COUNT is not set
+	defaultPostCount = 6
+	// defaultCacheTTL is used when BHP_CACHE_TTL is not set
+	defaultCacheTTL = 24 * time.Hour
+	// defaultCacheMaxStale is used when BHP_CACHE_MAX_STALE is not set
+	defaultCacheMaxStale = 7 * 24 * time.Hour
+)
+
+// getPostCount returns how many most recent posts are retained in the database and
+// served at most for the feed
+func getPostCount(feedID string) (int, error) {
+	postCountStr := utility.GetFeedSetting("BHP_POST_COUNT", feedID)
+	if postCountStr == "" {
+		return defaultPostCount, nil
+	}
+
+	postCount, err := strconv.Atoi(postCountStr)
+	if err != nil || postCount < 1 {
+		return 0, fmt.Errorf("invalid BHP_POST_COUNT %s for feed %s", postCountStr, feedID)
+	}
+
+	return postCount, nil
+}
+
+// getFeedDuration parses per-feed setting name as Go duration
+func getFeedDuration(name, feedID string, defaultValue time.Duration) (time.Duration, error) {
+	durationStr := utility.GetFeedSetting(name, feedID)
+	if durationStr == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(durationStr)
 	if err != nil {
-		return fmt.Errorf("failed to fetch feed from db: %w", err)
+		return 0, fmt.Errorf("invalid %s %s for feed %s: %w", name, durationStr, feedID, err)
 	}
 
-	err = parseFeedRows(queryRes, f)
+	return duration, nil
+}
+
+// getCacheTTL returns how long the feed is served from the local database before
+// it is fetched again from Behold
+func getCacheTTL(feedID string) (time.Duration, error) {
+	return getFeedDuration("BHP_CACHE_TTL", feedID, defaultCacheTTL)
+}
+
+// getCacheMaxStale returns how long after cache ttl the feed is still served from
+// the local database if Behold can't be reached
+func getCacheMaxStale(feedID string) (time.Duration, error) {
+	return getFeedDuration("BHP_CACHE_MAX_STALE", feedID, defaultCacheMaxStale)
+}
+
+func (f *Feed) fetchOrCreateFeed(db *sql.DB, client *Client, limit int) error {
+	ttl, err := getCacheTTL(f.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get cache ttl: %w", err)
+	}
+
+	err = queryFeed(db, f, ttl, limit)
 	if err == nil {
 		log.Println("found feed from local database")
 	} else if errors.Is(err, ErrFeedNotFound) {
 		log.Println("feed not found from local database")
-		err = f.getFromBehold()
+		err = f.refreshFeed(db, client, ttl, limit)
 		if err != nil {
-			return fmt.Errorf("failed to get feed from Behold: %w", err)
+			return err
 		}
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch feed from db: %w", err)
+	}
 
-		err = f.insertToDB(db)
-		if err != nil {
-			return fmt.Errorf("failed to insert feed in database: %w", err)
+	f.ExpiresAt = f.FetchedAt.Add(ttl)
+
+	return nil
+}
+
+// refreshFeed fetches the feed from Behold and stores it to the database. Only one
+// process refreshes the feed at a time while others wait for it to complete.
+func (f *Feed) refreshFeed(db *sql.DB, client *Client, ttl time.Duration, limit int) error {
+	unlock, err := waitForLock(db, "feed:"+f.ID, feedLockTTL, func() bool {
+		return queryFeed(db, f, ttl, limit) == nil
+	})
+	if errors.Is(err, errLockNotNeeded) {
+		log.Println("feed was refreshed by another process")
+		return nil
+	}
+	if errors.Is(err, errLockTimeout) {
+		// another process is still fetching the feed from Behold
+		err = fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+	if err == nil {
+		defer unlock()
+		err = f.loadUpstreamValidators(db)
+	}
+	if err == nil {
+		err = withCircuitBreaker(db, beholdBreaker, func() error {
+			return f.getFromBehold(client)
+		})
+		if err != nil && !errors.Is(err, ErrFeedNotExists) && !errors.Is(err, errNotModified) {
+			err = fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
 		}
-	} else if err != nil {
-		return fmt.Errorf("failed to parse feed from rows: %w", err)
+	}
+	if errors.Is(err, errNotModified) {
+		log.Println("feed not modified in Behold")
+		return f.extendCache(db, ttl, limit)
+	}
+	if err != nil && !errors.Is(err, ErrFeedNotExists) {
+		staleErr := f.fetchStaleFeed(db, ttl, limit)
+		if staleErr == nil {
+			log.Printf("serving stale feed as it could not be refreshed: %s", err)
+			return nil
+		}
+		log.Printf("could not serve stale feed: %s", staleErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get feed from Behold: %w", err)
+	}
+
+	postCount, err := getPostCount(f.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get post count: %w", err)
+	}
+	// only the posts retained by PruneFeed are stored
+	f.trimPosts(postCount)
+	f.upstreamPostCount = postCount
+
+	err = f.insertToDB(db)
+	if err != nil {
+		return fmt.Errorf("failed to insert feed in database: %w", err)
+	}
+	f.refreshed = true
+
+	if !f.filter.IsEmpty() {
+		// the filter is applied when querying the stored posts
+		return queryFeed(db, f, ttl, limit)
+	}
+	f.trimPosts(limit)
+
+	return nil
+}
+
+// loadUpstreamValidators reads ETag and Last-Modified of the previous Behold response
+// for making a conditional request. They are not used if BHP_POST_COUNT has been raised
+// since, as the stored posts were trimmed to the previous count.
+func (f *Feed) loadUpstreamValidators(db *sql.DB) error {
+	err := db.QueryRow(
+		`SELECT upstream_etag, upstream_last_modified, upstream_post_count FROM feeds WHERE feed_id = ?;`,
+		f.ID,
+	).Scan(&f.upstreamETag, &f.upstreamLastModified, &f.upstreamPostCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query upstream validators: %w", err)
 	}
 
+	postCount, err := getPostCount(f.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get post count: %w", err)
+	}
+	if f.upstreamPostCount < postCount {
+		log.Printf("post count of feed %s raised from %d to %d, fetching all posts", f.ID, f.upstreamPostCount, postCount)
+		f.upstreamETag, f.upstreamLastModified = "", ""
+	}
 	return nil
 }
 
-func (f *Feed) populatePostImages(db *sql.DB) error {
-	relevantPostIDs, err := f.getRelevantPosts(db)
+// extendCache marks the feed in the database fetched now without rewriting its
+// posts and reads it back
+func (f *Feed) extendCache(db *sql.DB, ttl time.Duration, limit int) error {
+	_, err := db.Exec(`UPDATE feeds SET last_fetched = ? WHERE feed_id = ?;`, time.Now().UTC(), f.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get relevant post ids: %w", err)
+		return fmt.Errorf("failed to update last fetched: %w", err)
+	}
+
+	err = queryFeed(db, f, ttl, limit)
+	if err != nil {
+		return fmt.Errorf("failed to fetch feed from db: %w", err)
+	}
+	f.refreshed = true
+	return nil
+}
+
+// fetchStaleFeed reads expired feed from the database as long as it has not been
+// expired longer than the max-stale window
+func (f *Feed) fetchStaleFeed(db *sql.DB, ttl time.Duration, limit int) error {
+	maxStale, err := getCacheMaxStale(f.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get cache max-stale: %w", err)
+	}
+
+	err = queryFeed(db, f, ttl+maxStale, limit)
+	if err != nil {
+		return fmt.Errorf("failed to fetch stale feed from db: %w", err)
+	}
+
+	f.ExpiresAt = f.FetchedAt.Add(ttl)
+	f.stale = true
+
+	return nil
+}
+
+// IsStale reports whether the feed has expired but is served from the database
+// because it could not be refreshed from Behold
+func (f *Feed) IsStale() bool {
+	return f.stale
+}
+
+// IsRefreshed reports whether the feed was fetched from Behold for this request instead
+// of being served from the database
+func (f *Feed) IsRefreshed() bool {
+	return f.refreshed
+}
+
+// HasTemporaryMedia reports whether any media URL of the feed points to its external
+// source because the file could not be downloaded yet
+func (f *Feed) HasTemporaryMedia() bool {
+	return f.temporaryMedia
+}
+
+// trimPosts orders posts from the most recent and drops all but limit first posts
+func (f *Feed) trimPosts(limit int) {
+	slices.SortStableFunc(f.Posts, func(a, b Post) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+	if len(f.Posts) > limit {
+		f.Posts = f.Posts[:limit]
 	}
+}
 
-	imageURLs, err := ensurePostImagesExist(db, relevantPostIDs)
+func (f *Feed) populatePostImages(db *sql.DB, client *Client, media MediaSelection) error {
+	var err error
+	f.temporaryMedia, err = ensurePostImagesExist(db, client, f.Posts, media)
 	if err != nil {
 		return fmt.Errorf("failed to ensure post images exist: %w", err)
 	}
 
-	for i := range imageURLs {
-		f.Posts[i].MediaSmallUrl = imageURLs[i]
+	for i := range f.Posts {
+		small := f.Posts[i].Sizes.Small
+		f.Posts[i].MediaSmallUrl = small.Url
+		f.Posts[i].MediaSmallHeight = small.Height
+		f.Posts[i].MediaSmallWidth = small.Width
 	}
 
 	return nil
 }
 
 func (f *Feed) insertToDB(db *sql.DB) error {
-	f.lastFetched = time.Now()
+	f.FetchedAt = time.Now().UTC()
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -138,8 +419,9 @@ func (f *Feed) insertToDB(db *sql.DB) error {
 
 	_, err = tx.Exec(
 		`INSERT INTO feeds 
-		(feed_id, username, biography, profile_picture_url, website, followers_count, follows_count, last_fetched) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		(feed_id, username, biography, profile_picture_url, website, followers_count, follows_count, last_fetched,
+		upstream_etag, upstream_last_modified, upstream_post_count) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(feed_id) DO UPDATE SET
 		username = excluded.username,
 		biography = excluded.biography,
@@ -147,19 +429,28 @@ func (f *Feed) insertToDB(db *sql.DB) error {
 		website = excluded.website,
 		followers_count = excluded.followers_count,
 		follows_count = excluded.follows_count,
-		last_fetched = excluded.last_fetched;`,
+		last_fetched = excluded.last_fetched,
+		upstream_etag = excluded.upstream_etag,
+		upstream_last_modified = excluded.upstream_last_modified,
+		upstream_post_count = excluded.upstream_post_count;`,
 		f.ID, f.Username, f.Biography, f.ProfilePictureUrl, f.Website,
-		f.FollowersCount, f.FollowsCount, f.lastFetched,
+		f.FollowersCount, f.FollowsCount, f.FetchedAt,
+		f.upstreamETag, f.upstreamLastModified, f.upstreamPostCount,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert feed: %w", err)
 	}
 
 	for _, post := range f.Posts {
+		// timestamps are stored in UTC so that their text sorts chronologically
 		_, err = tx.Exec(
 			`INSERT INTO posts 
-			(post_id, feed_id, permalink, timestamp, media_type, media_small_url, media_small_height, media_small_width, caption, pruned_caption) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			(post_id, feed_id, permalink, timestamp, media_type,
+			media_small_url, media_small_height, media_small_width,
+			media_medium_url, media_medium_height, media_medium_width,
+			media_large_url, media_large_height, media_large_width,
+			video_url, thumbnail_url, caption, pruned_caption) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(post_id) DO UPDATE SET
 			feed_id = excluded.feed_id,
 			permalink = excluded.permalink,
@@ -168,110 +459,34 @@ func (f *Feed) insertToDB(db *sql.DB) error {
 			media_small_url = excluded.media_small_url,
 			media_small_height = excluded.media_small_height,
 			media_small_width = excluded.media_small_width,
+			media_medium_url = excluded.media_medium_url,
+			media_medium_height = excluded.media_medium_height,
+			media_medium_width = excluded.media_medium_width,
+			media_large_url = excluded.media_large_url,
+			media_large_height = excluded.media_large_height,
+			media_large_width = excluded.media_large_width,
+			video_url = excluded.video_url,
+			thumbnail_url = excluded.thumbnail_url,
 			caption = excluded.caption,
 			pruned_caption = excluded.pruned_caption;`,
-			post.ID, post.feedID, post.Permalink, post.Timestamp, post.MediaType,
-			post.mediaSmallExternalURL, post.MediaSmallHeight, post.MediaSmallWidth,
-			post.Caption, post.PrunedCaption,
+			post.ID, post.feedID, post.Permalink, post.Timestamp.UTC(), post.MediaType,
+			post.Sizes.Small.externalURL, post.Sizes.Small.Height, post.Sizes.Small.Width,
+			post.Sizes.Medium.externalURL, post.Sizes.Medium.Height, post.Sizes.Medium.Width,
+			post.Sizes.Large.externalURL, post.Sizes.Large.Height, post.Sizes.Large.Width,
+			post.videoExternalURL, post.thumbnailExternalURL, post.Caption, post.PrunedCaption,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert post: %w", err)
 		}
-	}
-
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return nil
-}
 
-func getImageDirectory() (string, error) {
-	imageDirectory := os.Getenv("BHP_IMAGE_DIRECTORY")
-	if imageDirectory == "" {
-		return "", errors.New("required environment variable image_directory is not set or is empty")
-	}
-
-	return imageDirectory, nil
-}
-
-func getImageURL() string {
-	return os.Getenv("BHP_IMAGE_URL")
-}
-
-func ensurePostImagesExist(db *sql.DB, postIDs []string) ([]string, error) {
-	internalURLs := make([]string, len(postIDs))
-	imageDirectory, err := getImageDirectory()
-	if err != nil {
-		return nil, fmt.Errorf("failed to check image file: %w", err)
-	}
-
-	for i, postID := range postIDs {
-		fileNameMediaSmall := postID + ".webp"
-		filePathMediaSmall := filepath.Join(imageDirectory, fileNameMediaSmall)
-		internalURLs[i] = fmt.Sprintf("%s/%s", getImageURL(), fileNameMediaSmall)
-
-		// If the image exists, skip download
-		if _, err := os.Stat(filePathMediaSmall); err == nil {
-			continue
-		} else if !errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("failed to check image file: %w", err)
-		}
-		// if image is not found, it is downloaded from external source
-		dlURL, err := getImageExternalURL(db, postID)
-		if errors.Is(err, ErrPostNotFound) {
-			return nil, fmt.Errorf("post %s not found: %w", postID, err)
-		}
+		err = insertChildren(tx, post)
 		if err != nil {
-			return nil, fmt.Errorf("error getting images external url: %w", err)
-		}
-		err = downloadImage(filePathMediaSmall, dlURL)
-		if err != nil {
-			return nil, fmt.Errorf("error downloading image: %w", err)
+			return fmt.Errorf("failed to insert children of post %s: %w", post.ID, err)
 		}
 	}
-	return internalURLs, nil
-}
 
-// ErrPostNotFound means that post with given ID can't be found in the database
-var ErrPostNotFound = errors.New("post not found")
-
-// getImageExternalURL returns the external download URL of image
-func getImageExternalURL(db *sql.DB, postID string) (string, error) {
-	query := `SELECT media_small_url FROM posts WHERE post_id = ?`
-	row := db.QueryRow(query, postID)
-	var url string
-	err := row.Scan(&url)
-	if errors.Is(err, sql.ErrNoRows) {
-		return "", ErrPostNotFound
-	} else if err != nil {
-		return "", fmt.Errorf("failed to query post image url: %w", err)
-	}
-	return url, nil
-}
-
-// downloadImage downloads the image from external source and saves it to image directory with filename:
-// image-directory/IMAGE_ID.webp
-func downloadImage(filePath, url string) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create image file: %w", err)
-	}
-	defer file.Close()
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download image: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download image, status: %d", resp.StatusCode)
-	}
-
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write image to file: %w", err)
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
@@ -280,61 +495,104 @@ func downloadImage(filePath, url string) error {
 // ErrFeedNotFound means that feed with given ID can't be found in the database
 var ErrFeedNotFound = errors.New("feed not found")
 
-// queryFeed tries to fetch feed and all of its posts from database
-func queryFeed(db *sql.DB, id string) (*sql.Rows, error) {
-	rows, err := db.Query(
+// queryFeed tries to fetch feed and its limit most recent posts selected by the feed
+// filter from database to the receiver pointer "feed". Feeds fetched longer than ttl
+// ago are reported as not found.
+func queryFeed(db *sql.DB, feed *Feed, ttl time.Duration, limit int) error {
+	row := db.QueryRow(
 		`SELECT
-    	feeds.feed_id,
         username,
         biography,
         profile_picture_url,
         website,
         followers_count,
         follows_count,
+        last_fetched
+    FROM feeds
+    WHERE feed_id = ?;`,
+		feed.ID,
+	)
+	err := row.Scan(
+		&feed.Username,
+		&feed.Biography,
+		&feed.ProfilePictureUrl,
+		&feed.Website,
+		&feed.FollowersCount,
+		&feed.FollowsCount,
+		&feed.FetchedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrFeedNotFound
+	} else if err != nil {
+		return fmt.Errorf("error querying feed: %w", err)
+	}
+
+	if time.Since(feed.FetchedAt) > ttl {
+		return ErrFeedNotFound
+	}
+
+	conditions, args := feed.filter.sqlConditions()
+	rows, err := db.Query(
+		`SELECT
         post_id,
-        posts.feed_id,
+        feed_id,
         permalink,
         timestamp,
         media_type,
         media_small_url,
         media_small_height,
         media_small_width,
+        media_medium_url,
+        media_medium_height,
+        media_medium_width,
+        media_large_url,
+        media_large_height,
+        media_large_width,
+        video_url,
+        thumbnail_url,
         caption,
         pruned_caption
-    FROM feeds
-    INNER JOIN posts ON feeds.feed_id = posts.feed_id
-    WHERE feeds.feed_id = ? AND last_fetched >= DATETIME('now', '-1 day');`,
-		id,
+    FROM posts
+    WHERE feed_id = ?`+conditions+`
+    ORDER BY timestamp DESC, post_id
+    LIMIT ?;`,
+		append(append([]any{feed.ID}, args...), limit)...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error querying feeds: %w", err)
+		return fmt.Errorf("error querying posts: %w", err)
+	}
+	defer rows.Close()
+
+	err = parsePostRows(rows, feed)
+	if err != nil {
+		return err
 	}
-	return rows, nil
+
+	return queryChildren(db, feed)
 }
 
-// parseFeedRows tries to parse database query result to the receiver pointer "feed"
-func parseFeedRows(rows *sql.Rows, feed *Feed) error {
+// parsePostRows tries to parse posts from database query result to the receiver pointer "feed"
+func parsePostRows(rows *sql.Rows, feed *Feed) error {
 	posts := make([]Post, 0)
-	resultCount := 0
 	for rows.Next() {
-		resultCount++
-		post := Post{}
+		post := Post{Children: make([]Child, 0)}
 		err := rows.Scan(
-			&feed.ID,
-			&feed.Username,
-			&feed.Biography,
-			&feed.ProfilePictureUrl,
-			&feed.Website,
-			&feed.FollowersCount,
-			&feed.FollowsCount,
 			&post.ID,
 			&post.feedID,
 			&post.Permalink,
 			&post.Timestamp,
 			&post.MediaType,
-			&post.mediaSmallExternalURL,
-			&post.MediaSmallHeight,
-			&post.MediaSmallWidth,
+			&post.Sizes.Small.externalURL,
+			&post.Sizes.Small.Height,
+			&post.Sizes.Small.Width,
+			&post.Sizes.Medium.externalURL,
+			&post.Sizes.Medium.Height,
+			&post.Sizes.Medium.Width,
+			&post.Sizes.Large.externalURL,
+			&post.Sizes.Large.Height,
+			&post.Sizes.Large.Width,
+			&post.videoExternalURL,
+			&post.thumbnailExternalURL,
 			&post.Caption,
 			&post.PrunedCaption,
 		)
@@ -343,92 +601,9 @@ func parseFeedRows(rows *sql.Rows, feed *Feed) error {
 		}
 		posts = append(posts, post)
 	}
-	if resultCount == 0 {
-		return ErrFeedNotFound
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
 	}
 	feed.Posts = posts
 	return nil
 }
-
-func (f *Feed) removeDeprecatedPosts(db *sql.DB) {
-	imageDirectory, err := getImageDirectory()
-	if err != nil {
-		log.Printf("could not remove deprecated posts: %w", err)
-		return
-	}
-
-	ids, err := f.getIrrelevantPosts(db)
-	if err != nil {
-		log.Printf("error getting post ids for feed %s: %s", f.ID, err)
-		return
-	}
-	// if feed has no irrelevant posts, function returns
-	if len(ids) == 0 {
-		return
-	}
-
-	query := `DELETE FROM posts WHERE post_id IN (?)`
-	_, err = db.Exec(query, ids)
-	if err != nil {
-		log.Printf("error deleting posts for feed %s: %s", f.ID, err)
-	}
-
-	for _, postID := range ids {
-		filePath := filepath.Join(imageDirectory, postID+".webp")
-		// check if file already doesn't exist
-		if _, err := os.Stat(filePath); err != nil {
-			continue
-		}
-		// if file exists, it is removed
-		err = os.Remove(filePath)
-		if err != nil {
-			log.Printf("error removing file %s: %s", filePath, err)
-		}
-	}
-}
-
-// getRelevantPosts returns the IDs of all relevant posts that belong to the Feed
-func (f *Feed) getRelevantPosts(db *sql.DB) ([]string, error) {
-	// get 6 most recent post IDs from feed
-	query := `SELECT post_id FROM posts WHERE feed_id = ? ORDER BY timestamp DESC LIMIT 6;`
-	rows, err := db.Query(query, f.ID)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching last 6 posts from feed: %w", err)
-	}
-	defer rows.Close()
-	postIDs := make([]string, 0)
-	for rows.Next() {
-		var postID string
-		err = rows.Scan(&postID)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning post ID from row: %w", err)
-		}
-		postIDs = append(postIDs, postID)
-	}
-	return postIDs, nil
-}
-
-// getIrrelevantPosts returns the IDs of all irrelevant (very old) posts that belong to the Feed
-func (f *Feed) getIrrelevantPosts(db *sql.DB) ([]string, error) {
-	// get all post IDs from the feed
-	query := `SELECT post_id FROM posts WHERE feed_id = ? ORDER BY timestamp;`
-	rows, err := db.Query(query, f.ID)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching last 6 posts from feed: %w", err)
-	}
-	defer rows.Close()
-	postIDs := make([]string, 0)
-	for rows.Next() {
-		var postID string
-		err = rows.Scan(&postID)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning post ID from row: %w", err)
-		}
-		postIDs = append(postIDs, postID)
-	}
-	if len(postIDs) > 6 {
-		// 6 most recent posts are still relevant so skip them
-		return postIDs[6:], nil
-	}
-	return []string{}, nil
-}
@@ -0,0 +1,258 @@
+package feed
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/db"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	database, err := db.OpenSqliteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	err = db.InitSqliteDB(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return database
+}
+
+func insertTestFeed(t *testing.T, database *sql.DB, feedID string, fetchedAt time.Time) {
+	_, err := database.Exec(
+		`INSERT INTO feeds
+		(feed_id, username, biography, profile_picture_url, website, followers_count, follows_count, last_fetched)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		feedID, "user", "", "", "", 0, 0, fetchedAt,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTestPosts(t *testing.T, database *sql.DB, feedID string, count int) {
+	for i := range count {
+		_, err := database.Exec(
+			`INSERT INTO posts
+			(post_id, feed_id, permalink, timestamp, media_type, media_small_url, media_small_height, media_small_width, caption, pruned_caption)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fmt.Sprintf("post%02d", i), feedID, "", time.Date(2025, 1, i+1, 0, 0, 0, 0, time.UTC), "IMAGE", "", 0, 0, "", "",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueryFeedTTL(t *testing.T) {
+	database := newTestDB(t)
+	fetchedAt := time.Now().UTC().Add(-2 * time.Hour)
+	insertTestFeed(t, database, "feed1", fetchedAt)
+
+	f := &Feed{ID: "feed1"}
+	err := queryFeed(database, f, 3*time.Hour, defaultPostCount)
+	if err != nil {
+		t.Errorf("queryFeed returned an error for fresh feed: %s", err)
+	}
+	if !f.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("expected fetchedAt %s, got %s", fetchedAt, f.FetchedAt)
+	}
+
+	err = queryFeed(database, &Feed{ID: "feed1"}, time.Hour, defaultPostCount)
+	if err != ErrFeedNotFound {
+		t.Errorf("expected ErrFeedNotFound for expired feed, got %v", err)
+	}
+}
+
+func TestGetCacheTTL(t *testing.T) {
+	t.Setenv("BHP_CACHE_TTL", "")
+	ttl, err := getCacheTTL("feed1")
+	if err != nil || ttl != defaultCacheTTL {
+		t.Errorf("expected default ttl %s, got %s (%v)", defaultCacheTTL, ttl, err)
+	}
+
+	t.Setenv("BHP_CACHE_TTL", "168h")
+	t.Setenv("BHP_CACHE_TTL_PER_FEED", "feed1=1h")
+	ttl, err = getCacheTTL("feed1")
+	if err != nil || ttl != time.Hour {
+		t.Errorf("expected per-feed ttl 1h, got %s (%v)", ttl, err)
+	}
+	ttl, err = getCacheTTL("feed2")
+	if err != nil || ttl != 168*time.Hour {
+		t.Errorf("expected global ttl 168h, got %s (%v)", ttl, err)
+	}
+
+	t.Setenv("BHP_CACHE_TTL", "weekly")
+	_, err = getCacheTTL("feed2")
+	if err == nil {
+		t.Errorf("expected error for invalid ttl")
+	}
+}
+
+func TestFetchStaleFeed(t *testing.T) {
+	database := newTestDB(t)
+	insertTestFeed(t, database, "feed1", time.Now().UTC().Add(-48*time.Hour))
+	t.Setenv("BHP_CACHE_MAX_STALE", "36h")
+
+	f := &Feed{ID: "feed1"}
+	err := f.fetchStaleFeed(database, 24*time.Hour, defaultPostCount)
+	if err != nil {
+		t.Fatalf("fetchStaleFeed returned an error: %s", err)
+	}
+	if !f.IsStale() || f.IsRefreshed() {
+		t.Errorf("expected feed to be marked stale and not refreshed")
+	}
+	if !f.ExpiresAt.Before(time.Now()) {
+		t.Errorf("expected stale feed to have expired, expiresAt %s", f.ExpiresAt)
+	}
+
+	t.Setenv("BHP_CACHE_MAX_STALE", "12h")
+	err = (&Feed{ID: "feed1"}).fetchStaleFeed(database, 24*time.Hour, defaultPostCount)
+	if err == nil {
+		t.Errorf("expected error for feed older than max-stale window")
+	}
+}
+
+func TestPostCount(t *testing.T) {
+	database := newTestDB(t)
+	insertTestFeed(t, database, "feed1", time.Now().UTC())
+	insertTestPosts(t, database, "feed1", 15)
+	t.Setenv("BHP_POST_COUNT", "12")
+
+	f := &Feed{ID: "feed1"}
+	err := queryFeed(database, f, time.Hour, 4)
+	if err != nil {
+		t.Fatalf("queryFeed returned an error: %s", err)
+	}
+	if len(f.Posts) != 4 {
+		t.Fatalf("expected 4 posts, got %d", len(f.Posts))
+	}
+	if f.Posts[0].ID != "post14" || f.Posts[3].ID != "post11" {
+		t.Errorf("expected most recent posts first, got %s..%s", f.Posts[0].ID, f.Posts[3].ID)
+	}
+
+	t.Setenv("BHP_POST_COUNT", "0")
+	_, err = getPostCount("feed1")
+	if err == nil {
+		t.Errorf("expected error for invalid post count")
+	}
+}
+
+func TestTrimPosts(t *testing.T) {
+	f := &Feed{Posts: []Post{
+		{ID: "old", Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "new", Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "middle", Timestamp: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}}
+
+	f.trimPosts(2)
+	if len(f.Posts) != 2 || f.Posts[0].ID != "new" || f.Posts[1].ID != "middle" {
+		t.Errorf("expected posts new and middle, got %v", f.Posts)
+	}
+}
+
+func TestInsertAndQueryChildren(t *testing.T) {
+	database := newTestDB(t)
+
+	f := &Feed{ID: "feed1", Posts: []Post{{
+		ID:        "carousel1",
+		feedID:    "feed1",
+		MediaType: "CAROUSEL_ALBUM",
+		Children: []Child{
+			{ID: "child1", MediaType: "IMAGE", Sizes: Sizes{Small: Media{externalURL: "https://example.com/child1.webp"}}},
+			{ID: "child2", MediaType: "VIDEO", videoExternalURL: "https://example.com/child2.mp4"},
+		},
+	}}}
+	err := f.insertToDB(database)
+	if err != nil {
+		t.Fatalf("insertToDB returned an error: %s", err)
+	}
+
+	queried := &Feed{ID: "feed1"}
+	err = queryFeed(database, queried, time.Hour, defaultPostCount)
+	if err != nil {
+		t.Fatalf("queryFeed returned an error: %s", err)
+	}
+	if len(queried.Posts) != 1 || len(queried.Posts[0].Children) != 2 {
+		t.Fatalf("expected 1 post with 2 children, got %+v", queried.Posts)
+	}
+	children := queried.Posts[0].Children
+	if children[0].ID != "child1" || children[0].Sizes.Small.externalURL != "https://example.com/child1.webp" {
+		t.Errorf("unexpected first child %+v", children[0])
+	}
+	if children[1].ID != "child2" || children[1].videoExternalURL != "https://example.com/child2.mp4" {
+		t.Errorf("unexpected second child %+v", children[1])
+	}
+}
+
+func TestGetFeedWithIDErrors(t *testing.T) {
+	database := newTestDB(t)
+	t.Setenv("BHP_ALLOWED_FEED_IDS", "1234,5678")
+	t.Setenv("BHP_BREAKER_THRESHOLD", "100")
+
+	client := &Client{
+		BaseURL:     "http://behold.test/",
+		FeedTimeout: time.Second,
+		HTTPClient:  &MockHTTPClient{Error: errors.New("connection refused")},
+	}
+
+	tests := map[string]error{
+		"":     ErrInvalidFeedID,
+		"9999": ErrFeedNotAllowed,
+		"1234": ErrUpstreamUnavailable,
+	}
+	for id, expected := range tests {
+		_, err := GetFeedWithID(database, client, id, 0)
+		if !errors.Is(err, expected) {
+			t.Errorf("feed %q: expected error %v, got %v", id, expected, err)
+		}
+	}
+
+	client.HTTPClient = &MockHTTPClient{Response: &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}}
+	_, err := GetFeedWithID(database, client, "5678", 0)
+	if !errors.Is(err, ErrFeedNotExists) || errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("expected only ErrFeedNotExists, got %v", err)
+	}
+
+	// another process is refreshing the feed for longer than BHP_LOCK_WAIT
+	t.Setenv("BHP_LOCK_WAIT", "100ms")
+	_, err = database.Exec(`INSERT INTO locks (name, holder, expires_at) VALUES (?, ?, ?)`,
+		"feed:1234", "other", time.Now().Add(time.Minute).UnixNano())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetFeedWithID(database, client, "1234", 0)
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("expected lock timeout to be ErrUpstreamUnavailable, got %v", err)
+	}
+}
+
+func TestIsValidFeedID(t *testing.T) {
+	validIDs := []string{"JYK0zcST7PconDbzq1GL", "1234"}
+	for _, id := range validIDs {
+		if !IsValidFeedID(id) {
+			t.Errorf("expected %q to be valid", id)
+		}
+	}
+
+	invalidIDs := []string{"", "../1234", "..", "1234/../admin", "1234?x=1", "12 34", "%2e%2e", strings.Repeat("a", 65)}
+	for _, id := range invalidIDs {
+		if IsValidFeedID(id) {
+			t.Errorf("expected %q to be invalid", id)
+		}
+	}
+}
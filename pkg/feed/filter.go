@@ -0,0 +1,75 @@
+package feed
+
+import (
+	"strings"
+	"time"
+)
+
+// media types of Behold posts
+const (
+	MediaTypeImage         = "IMAGE"
+	MediaTypeVideo         = "VIDEO"
+	MediaTypeCarouselAlbum = "CAROUSEL_ALBUM"
+)
+
+// PostFilter selects a subset of the stored posts of a feed. Zero value selects all posts.
+type PostFilter struct {
+	// MediaTypes lists the accepted media types, e.g. MediaTypeVideo
+	MediaTypes []string
+	// Hashtag is a hashtag without # the caption must contain
+	Hashtag string
+	// Keyword is a text the caption must contain, ignoring case
+	Keyword string
+	// Since and Until limit the post timestamps, both inclusive
+	Since time.Time
+	Until time.Time
+}
+
+// IsEmpty reports whether the filter selects all posts
+func (pf PostFilter) IsEmpty() bool {
+	return len(pf.MediaTypes) == 0 && pf.Hashtag == "" && pf.Keyword == "" && pf.Since.IsZero() && pf.Until.IsZero()
+}
+
+// hashtagEnd matches a character which can't be part of a hashtag. Letters outside
+// ASCII are part of hashtags, e.g. #kesä.
+const hashtagEnd = "[^a-z0-9_\u0080-\U0010FFFF]"
+
+// sqlConditions returns the filter as SQL conditions of the posts table to be
+// appended to a WHERE clause, together with their arguments
+func (pf PostFilter) sqlConditions() (string, []any) {
+	var conditions []string
+	var args []any
+
+	if len(pf.MediaTypes) > 0 {
+		inList, mediaTypeArgs := placeholders(pf.MediaTypes)
+		conditions = append(conditions, "media_type IN ("+inList+")")
+		args = append(args, mediaTypeArgs...)
+	}
+
+	if pf.Hashtag != "" {
+		// the hashtag may be followed by any other character than those of hashtags
+		hashtag := "*#" + strings.ToLower(pf.Hashtag)
+		conditions = append(conditions, "(unicode_lower(caption) GLOB ? OR unicode_lower(caption) GLOB ?)")
+		args = append(args, hashtag, hashtag+hashtagEnd+"*")
+	}
+
+	if pf.Keyword != "" {
+		conditions = append(conditions, "instr(unicode_lower(caption), ?) > 0")
+		args = append(args, strings.ToLower(pf.Keyword))
+	}
+
+	// insertToDB stores timestamps in UTC, so the text of UTC times compares chronologically
+	if !pf.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, pf.Since.UTC())
+	}
+	if !pf.Until.IsZero() {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, pf.Until.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
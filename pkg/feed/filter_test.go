@@ -0,0 +1,111 @@
+package feed
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQueryFeedWithFilter(t *testing.T) {
+	database := newTestDB(t)
+	insertTestFeed(t, database, "feed1", time.Now().UTC())
+
+	posts := []struct {
+		id        string
+		mediaType string
+		caption   string
+		timestamp time.Time
+	}{
+		{"beach", MediaTypeImage, "Sunny day at the beach #Summer #sea", time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)},
+		{"video", MediaTypeVideo, "Waves #summer.", time.Date(2025, 7, 15, 8, 30, 0, 0, time.UTC)},
+		{"winter", MediaTypeCarouselAlbum, "Skiing #summertime_is_over #KESÄ Äiti", time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)},
+		{"other", MediaTypeImage, "Nothing to see\n#summer", time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)},
+	}
+	for _, post := range posts {
+		_, err := database.Exec(
+			`INSERT INTO posts
+			(post_id, feed_id, permalink, timestamp, media_type, media_small_url, media_small_height, media_small_width, caption, pruned_caption)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			post.id, "feed1", "", post.timestamp, post.mediaType, "", 0, 0, post.caption, "",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   PostFilter
+		expected []string
+	}{
+		{"no filter", PostFilter{}, []string{"video", "beach", "winter", "other"}},
+		{"media type", PostFilter{MediaTypes: []string{MediaTypeImage, MediaTypeCarouselAlbum}}, []string{"beach", "winter", "other"}},
+		{"hashtag", PostFilter{Hashtag: "summer"}, []string{"video", "beach", "other"}},
+		{"hashtag with letters outside ASCII", PostFilter{Hashtag: "kesä"}, []string{"winter"}},
+		{"hashtag prefix", PostFilter{Hashtag: "kes"}, nil},
+		{"keyword", PostFilter{Keyword: "BEACH"}, []string{"beach"}},
+		{"keyword with letters outside ASCII", PostFilter{Keyword: "äiti"}, []string{"winter"}},
+		{"since", PostFilter{Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, []string{"video", "beach", "winter"}},
+		{"until", PostFilter{Until: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)}, []string{"beach", "winter", "other"}},
+		{
+			"combined",
+			PostFilter{MediaTypes: []string{MediaTypeImage}, Hashtag: "summer", Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.FixedZone("EET", 2*3600))},
+			[]string{"beach", "other"},
+		},
+	}
+
+	for _, test := range tests {
+		f := &Feed{ID: "feed1", filter: test.filter}
+		err := queryFeed(database, f, time.Hour, defaultPostCount)
+		if err != nil {
+			t.Fatalf("%s: queryFeed returned an error: %s", test.name, err)
+		}
+
+		var ids []string
+		for _, post := range f.Posts {
+			ids = append(ids, post.ID)
+		}
+		if !slices.Equal(ids, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ids)
+		}
+	}
+}
+
+func TestFilterTimestampsInOtherZones(t *testing.T) {
+	database := newTestDB(t)
+
+	// Behold timestamps with offset +0000 are parsed to a zone other than UTC
+	beholdZone := time.FixedZone("", 0)
+	helsinki := time.FixedZone("EEST", 3*3600)
+	f := &Feed{ID: "feed1", Posts: []Post{
+		{ID: "morning", feedID: "feed1", Timestamp: time.Date(2025, 6, 30, 9, 0, 0, 0, helsinki)},
+		{ID: "noon", feedID: "feed1", Timestamp: time.Date(2025, 6, 30, 12, 0, 0, 0, beholdZone)},
+	}}
+	if err := f.insertToDB(database); err != nil {
+		t.Fatalf("insertToDB returned an error: %s", err)
+	}
+
+	tests := []struct {
+		filter   PostFilter
+		expected []string
+	}{
+		{PostFilter{Since: time.Date(2025, 6, 30, 6, 0, 0, 0, time.UTC)}, []string{"noon", "morning"}},
+		{PostFilter{Since: time.Date(2025, 6, 30, 6, 0, 1, 0, time.UTC)}, []string{"noon"}},
+		{PostFilter{Until: time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)}, []string{"noon", "morning"}},
+		{PostFilter{Until: time.Date(2025, 6, 30, 14, 59, 59, 0, helsinki)}, []string{"morning"}},
+	}
+	for _, test := range tests {
+		queried := &Feed{ID: "feed1", filter: test.filter}
+		if err := queryFeed(database, queried, time.Hour, defaultPostCount); err != nil {
+			t.Fatalf("queryFeed returned an error: %s", err)
+		}
+
+		var ids []string
+		for _, post := range queried.Posts {
+			ids = append(ids, post.ID)
+		}
+		if !slices.Equal(ids, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.filter, test.expected, ids)
+		}
+	}
+}
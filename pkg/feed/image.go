@@ -0,0 +1,433 @@
+package feed
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageSizes lists the image sizes stored for each post
+var imageSizes = []string{"small", "medium", "large"}
+
+// safeMediaID matches media IDs which can be used as file names as such
+var safeMediaID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$`)
+
+// mediaFileBase returns the base of file names of the post or carousel child. Media
+// IDs come from upstream, so IDs which are not plain alphanumeric, e.g. "../x", are
+// replaced by a hash. The leading underscore prevents collisions with safe IDs.
+func mediaFileBase(mediaID string) string {
+	if safeMediaID.MatchString(mediaID) {
+		return mediaID
+	}
+
+	sum := sha256.Sum256([]byte(mediaID))
+	return "_" + hex.EncodeToString(sum[:16])
+}
+
+// imageFileName returns the name of the image file of the post or carousel child
+// in the given size. Small images keep their original name image-directory/POST_ID.webp.
+func imageFileName(mediaID, size string) string {
+	if size == "small" {
+		return mediaFileBase(mediaID) + ".webp"
+	}
+
+	return mediaFileBase(mediaID) + "-" + size + ".webp"
+}
+
+// videoFileName returns the name of the video file of the post or carousel child
+func videoFileName(mediaID string) string {
+	return mediaFileBase(mediaID) + ".mp4"
+}
+
+// thumbnailFileName returns the name of the video thumbnail of the post or carousel child
+func thumbnailFileName(mediaID string) string {
+	return mediaFileBase(mediaID) + "-thumbnail.jpg"
+}
+
+// mediaFileNames lists all files a post or carousel child may have in the image directory
+func mediaFileNames(mediaID string) []string {
+	fileNames := make([]string, 0, len(imageSizes)+2)
+	for _, size := range imageSizes {
+		fileNames = append(fileNames, imageFileName(mediaID, size))
+	}
+
+	return append(fileNames, videoFileName(mediaID), thumbnailFileName(mediaID))
+}
+
+// mediaFile is a single file of a post stored in the image directory
+type mediaFile struct {
+	fileName    string
+	externalURL string
+	// url is set to the internal URL of the file
+	url *string
+}
+
+// sizeFiles lists the image files of all sizes
+func sizeFiles(mediaID string, sizes *Sizes) []mediaFile {
+	return []mediaFile{
+		{imageFileName(mediaID, "small"), sizes.Small.externalURL, &sizes.Small.Url},
+		{imageFileName(mediaID, "medium"), sizes.Medium.externalURL, &sizes.Medium.Url},
+		{imageFileName(mediaID, "large"), sizes.Large.externalURL, &sizes.Large.Url},
+	}
+}
+
+// mediaFiles lists all files of the post including its video and carousel children
+func (p *Post) mediaFiles() []mediaFile {
+	files := sizeFiles(p.ID, &p.Sizes)
+	files = append(files,
+		mediaFile{videoFileName(p.ID), p.videoExternalURL, &p.VideoUrl},
+		mediaFile{thumbnailFileName(p.ID), p.thumbnailExternalURL, &p.ThumbnailUrl},
+	)
+
+	for i := range p.Children {
+		child := &p.Children[i]
+		files = append(files, sizeFiles(child.ID, &child.Sizes)...)
+		files = append(files,
+			mediaFile{videoFileName(child.ID), child.videoExternalURL, &child.VideoUrl},
+			mediaFile{thumbnailFileName(child.ID), child.thumbnailExternalURL, &child.ThumbnailUrl},
+		)
+	}
+
+	return files
+}
+
+// MediaSelection tells which media files of the posts a response uses
+type MediaSelection int
+
+const (
+	// AllMedia includes images of all sizes, videos and carousel children
+	AllMedia MediaSelection = iota
+	// SmallImages includes only the small image of each post, e.g. for RSS and widgets
+	SmallImages
+)
+
+// selectedFiles lists the files of the post included in the media selection
+func (p *Post) selectedFiles(media MediaSelection) []mediaFile {
+	if media == SmallImages {
+		return []mediaFile{{imageFileName(p.ID, "small"), p.Sizes.Small.externalURL, &p.Sizes.Small.Url}}
+	}
+	return p.mediaFiles()
+}
+
+func getImageDirectory() (string, error) {
+	imageDirectory := os.Getenv("BHP_IMAGE_DIRECTORY")
+	if imageDirectory == "" {
+		return "", errors.New("required environment variable image_directory is not set or is empty")
+	}
+
+	return imageDirectory, nil
+}
+
+func getImageURL() string {
+	return os.Getenv("BHP_IMAGE_URL")
+}
+
+const (
+	// defaultImageConcurrency is used when BHP_IMAGE_CONCURRENCY is not set
+	defaultImageConcurrency = 4
+	// defaultImageTimeout is used when BHP_IMAGE_TIMEOUT is not set
+	defaultImageTimeout = 30 * time.Second
+)
+
+// getImageConcurrency returns how many media files are downloaded in parallel
+func getImageConcurrency() (int, error) {
+	concurrencyStr := os.Getenv("BHP_IMAGE_CONCURRENCY")
+	if concurrencyStr == "" {
+		return defaultImageConcurrency, nil
+	}
+
+	concurrency, err := strconv.Atoi(concurrencyStr)
+	if err != nil || concurrency < 1 {
+		return 0, fmt.Errorf("invalid BHP_IMAGE_CONCURRENCY %s", concurrencyStr)
+	}
+
+	return concurrency, nil
+}
+
+// getImageTimeout returns how long a single media file download may take
+func getImageTimeout() (time.Duration, error) {
+	timeoutStr := os.Getenv("BHP_IMAGE_TIMEOUT")
+	if timeoutStr == "" {
+		return defaultImageTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BHP_IMAGE_TIMEOUT %s: %w", timeoutStr, err)
+	}
+
+	return timeout, nil
+}
+
+// download is a single missing media file
+type download struct {
+	postID      string
+	fileName    string
+	filePath    string
+	externalURL string
+	// url is the URL of the file set to the post
+	url *string
+}
+
+// ensurePostImagesExist downloads the missing media files of the selection and sets their
+// internal URLs to the posts. Files outside the selection are neither downloaded nor set.
+// It reports whether any file is served from its external source until it is downloaded.
+func ensurePostImagesExist(db *sql.DB, client *Client, posts []Post, media MediaSelection) (bool, error) {
+	imageDirectory, err := getImageDirectory()
+	if err != nil {
+		return false, fmt.Errorf("failed to check image file: %w", err)
+	}
+
+	downloads := make([]download, 0)
+	for i := range posts {
+		for _, file := range posts[i].selectedFiles(media) {
+			// posts stored before all media were supported lack some external URLs
+			if file.externalURL == "" {
+				continue
+			}
+
+			filePath := filepath.Join(imageDirectory, file.fileName)
+			*file.url = fmt.Sprintf("%s/%s", getImageURL(), file.fileName)
+
+			// If the image exists, skip download
+			if _, err := os.Stat(filePath); err == nil {
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return false, fmt.Errorf("failed to check image file: %w", err)
+			}
+			// if image is not found, it is downloaded from external source
+			downloads = append(downloads, download{
+				postID:      posts[i].ID,
+				fileName:    file.fileName,
+				filePath:    filePath,
+				externalURL: file.externalURL,
+				url:         file.url,
+			})
+		}
+	}
+
+	return downloadAll(db, client, downloads)
+}
+
+// downloadAll downloads media files in parallel by a bounded pool of workers. A file
+// which can't be downloaded doesn't fail the feed. It is logged and clients load it
+// from its external source instead, unless the source is not allowed. It reports
+// whether any file is served from its external source.
+func downloadAll(db *sql.DB, client *Client, downloads []download) (bool, error) {
+	concurrency, err := getImageConcurrency()
+	if err != nil {
+		return false, err
+	}
+	timeout, err := getImageTimeout()
+	if err != nil {
+		return false, err
+	}
+
+	errs := make([]error, len(downloads))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(downloads)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = downloads[i].run(db, client, timeout)
+			}
+		}()
+	}
+
+	for i := range downloads {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	external := false
+	for i, err := range errs {
+		d := downloads[i]
+		switch {
+		case err == nil:
+		case errors.Is(err, errLockTimeout):
+			// the file doesn't exist yet, so clients load it from its external source meanwhile
+			log.Printf("image %s is still being downloaded by another process", d.fileName)
+			*d.url = d.externalURL
+			external = true
+		case errors.Is(err, ErrMediaNotAllowed):
+			log.Printf("error downloading %s of post %s: %s", d.fileName, d.postID, err)
+			*d.url = ""
+		default:
+			log.Printf("error downloading %s of post %s: %s", d.fileName, d.postID, err)
+			*d.url = d.externalURL
+			external = true
+		}
+	}
+	return external, nil
+}
+
+// run downloads the media file unless another process is already downloading it
+func (d download) run(db *sql.DB, client *Client, timeout time.Duration) error {
+	unlock, err := waitForLock(db, "image:"+d.fileName, imageLockTTL, func() bool {
+		_, err := os.Stat(d.filePath)
+		return err == nil
+	})
+	if errors.Is(err, errLockNotNeeded) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock image %s: %w", d.fileName, err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return client.downloadImage(ctx, d.filePath, d.externalURL)
+}
+
+// defaultMaxMediaBytes is used when BHP_MEDIA_MAX_BYTES is not set
+const defaultMaxMediaBytes = 50 << 20
+
+// ErrInvalidMedia means that downloaded file is not the expected image or video
+var ErrInvalidMedia = errors.New("invalid media file")
+
+// getMaxMediaBytes returns the maximum size of a single downloaded image or video
+func getMaxMediaBytes() (int64, error) {
+	maxBytesStr := os.Getenv("BHP_MEDIA_MAX_BYTES")
+	if maxBytesStr == "" {
+		return defaultMaxMediaBytes, nil
+	}
+
+	maxBytes, err := strconv.ParseInt(maxBytesStr, 10, 64)
+	if err != nil || maxBytes < 1 {
+		return 0, fmt.Errorf("invalid BHP_MEDIA_MAX_BYTES %s", maxBytesStr)
+	}
+
+	return maxBytes, nil
+}
+
+// expectedContentType returns the content type prefix of the file based on its extension
+func expectedContentType(filePath string) string {
+	if filepath.Ext(filePath) == ".mp4" {
+		return "video/"
+	}
+	return "image/"
+}
+
+// downloadImage downloads the image or video from external source and saves it to filePath.
+// The file is written to a temporary file first and renamed in place only after it has
+// been validated so that failed downloads never leave partial files behind. Media is
+// downloaded only from the hosts of the client and never from internal addresses.
+func (c *Client) downloadImage(ctx context.Context, filePath, mediaURL string) error {
+	maxBytes, err := getMaxMediaBytes()
+	if err != nil {
+		return err
+	}
+
+	parsedURL, err := url.Parse(mediaURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid URL: %w", ErrMediaNotAllowed, err)
+	}
+	if err = checkMediaURL(parsedURL, c.MediaHosts); err != nil {
+		return err
+	}
+
+	resp, err := c.get(ctx, c.MediaHTTPClient, mediaURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download image, status: %d", resp.StatusCode)
+	}
+
+	// the content is checked from its magic bytes, as CDNs may serve media e.g. as
+	// application/octet-stream
+	expectedType := expectedContentType(filePath)
+	if contentType := resp.Header.Get("Content-Type"); contradictsContentType(contentType, expectedType) {
+		return fmt.Errorf("%w: content type %s, expected %s*", ErrInvalidMedia, contentType, expectedType)
+	}
+	if resp.ContentLength > maxBytes {
+		return fmt.Errorf("%w: size %d exceeds %d bytes", ErrInvalidMedia, resp.ContentLength, maxBytes)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary image file: %w", err)
+	}
+	defer func() {
+		file.Close()
+		// after successful rename the temporary file no longer exists
+		os.Remove(file.Name())
+	}()
+
+	// one byte over the limit is read to detect too large files
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to write image to file: %w", err)
+	}
+	if written > maxBytes {
+		return fmt.Errorf("%w: size exceeds %d bytes", ErrInvalidMedia, maxBytes)
+	}
+
+	err = validateMediaFile(file, expectedType)
+	if err != nil {
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close image file: %w", err)
+	}
+	if err = os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to move image file in place: %w", err)
+	}
+
+	return nil
+}
+
+// contradictingTypes lists the top-level media types which are never served for images or videos
+var contradictingTypes = []string{"text", "image", "video", "audio", "font", "model", "multipart"}
+
+// contradictsContentType reports whether the Content-Type header names a media type which
+// the file clearly is not. Missing, malformed and generic types, e.g. application/*, are not.
+func contradictsContentType(contentType, expectedType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	topLevelType, _, _ := strings.Cut(mediaType, "/")
+	return slices.Contains(contradictingTypes, topLevelType) && topLevelType+"/" != expectedType
+}
+
+// validateMediaFile checks from the magic bytes that the file content is of expected type
+func validateMediaFile(file *os.File, expectedType string) error {
+	header := make([]byte, 512)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read image file: %w", err)
+	}
+
+	detectedType := http.DetectContentType(header[:n])
+	if !strings.HasPrefix(detectedType, expectedType) {
+		return fmt.Errorf("%w: detected content type %s, expected %s*", ErrInvalidMedia, detectedType, expectedType)
+	}
+
+	return nil
+}
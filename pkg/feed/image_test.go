@@ -0,0 +1,374 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/utility"
+)
+
+func newTestClient(t *testing.T) *Client {
+	// test servers listen on loopback
+	t.Setenv("BHP_MEDIA_ALLOWED_HOSTS", "127.0.0.1")
+	t.Setenv("BHP_MEDIA_ALLOW_PRIVATE_IPS", "true")
+
+	client, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestMediaFileNamesAreSafe(t *testing.T) {
+	safeIDs := []string{"18056393722961337", "post00", "abc_DEF-123"}
+	for _, id := range safeIDs {
+		if mediaFileBase(id) != id {
+			t.Errorf("expected safe ID %s to be used as such, got %s", id, mediaFileBase(id))
+		}
+	}
+
+	unsafeIDs := []string{"", "../secret", "..", "a/../../b", `..\windows`, "/etc/passwd", ".hidden", "-rf", "a b", strings.Repeat("a", 200)}
+	seen := map[string]bool{}
+	for _, id := range unsafeIDs {
+		for _, fileName := range mediaFileNames(id) {
+			if filepath.Base(fileName) != fileName || strings.Contains(fileName, "..") || !strings.HasPrefix(fileName, "_") {
+				t.Errorf("unsafe file name %q for ID %q", fileName, id)
+			}
+		}
+
+		base := mediaFileBase(id)
+		if seen[base] {
+			t.Errorf("file name %s of ID %q collides with another ID", base, id)
+		}
+		seen[base] = true
+	}
+}
+
+func TestEnsurePostImagesExist(t *testing.T) {
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	t.Setenv("BHP_IMAGE_URL", "/images")
+
+	for _, size := range imageSizes {
+		err := os.WriteFile(filepath.Join(imageDirectory, imageFileName("post1", size)), []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	posts := []Post{{
+		ID: "post1",
+		Sizes: Sizes{
+			Small:  Media{externalURL: "https://example.com/small.webp"},
+			Medium: Media{externalURL: "https://example.com/medium.webp"},
+			Large:  Media{externalURL: "https://example.com/large.webp"},
+		},
+	}, {
+		ID: "post2",
+	}}
+
+	_, err := ensurePostImagesExist(newTestDB(t), newTestClient(t), posts, AllMedia)
+	if err != nil {
+		t.Fatalf("ensurePostImagesExist returned an error: %s", err)
+	}
+
+	if posts[0].Sizes.Small.Url != "/images/post1.webp" {
+		t.Errorf("unexpected small image url %s", posts[0].Sizes.Small.Url)
+	}
+	if posts[0].Sizes.Medium.Url != "/images/post1-medium.webp" {
+		t.Errorf("unexpected medium image url %s", posts[0].Sizes.Medium.Url)
+	}
+	if posts[0].Sizes.Large.Url != "/images/post1-large.webp" {
+		t.Errorf("unexpected large image url %s", posts[0].Sizes.Large.Url)
+	}
+	if posts[1].Sizes.Medium.Url != "" {
+		t.Errorf("expected no url for post without external image, got %s", posts[1].Sizes.Medium.Url)
+	}
+}
+
+func TestMediaFilesOfCarousel(t *testing.T) {
+	post := &Post{
+		ID:                   "video1",
+		videoExternalURL:     "https://example.com/video1.mp4",
+		thumbnailExternalURL: "https://example.com/video1.jpg",
+		Children:             []Child{{ID: "child1", videoExternalURL: "https://example.com/child1.mp4"}},
+	}
+
+	files := post.mediaFiles()
+	fileNames := make(map[string]mediaFile)
+	for _, file := range files {
+		fileNames[file.fileName] = file
+	}
+
+	for _, fileName := range []string{"video1.mp4", "video1-thumbnail.jpg", "child1.webp", "child1.mp4"} {
+		if _, found := fileNames[fileName]; !found {
+			t.Errorf("expected media file %s, got %v", fileName, files)
+		}
+	}
+
+	*fileNames["child1.mp4"].url = "/images/child1.mp4"
+	if post.Children[0].VideoUrl != "/images/child1.mp4" {
+		t.Errorf("expected media file url to point to child video url")
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	webp := []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.webp":
+			w.Header().Set("Content-Type", "image/webp")
+			w.Write(webp)
+		case "/html.webp":
+			w.Header().Set("Content-Type", "image/webp")
+			w.Write([]byte("<html><body>not an image</body></html>"))
+		case "/large.webp":
+			w.Header().Set("Content-Type", "image/webp")
+			w.Write(append(webp, make([]byte, 100)...))
+		case "/octet-stream.webp":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(webp)
+		case "/text.webp":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(webp)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_MEDIA_MAX_BYTES", "64")
+
+	filePath := filepath.Join(imageDirectory, "post1.webp")
+	err := newTestClient(t).downloadImage(context.Background(), filePath, server.URL+"/image.webp")
+	if err != nil {
+		t.Fatalf("downloadImage returned an error: %s", err)
+	}
+	if content, _ := os.ReadFile(filePath); !bytes.Equal(content, webp) {
+		t.Errorf("unexpected image content %q", content)
+	}
+
+	// generic content type is accepted as the magic bytes are valid
+	filePath = filepath.Join(imageDirectory, "post2.webp")
+	err = newTestClient(t).downloadImage(context.Background(), filePath, server.URL+"/octet-stream.webp")
+	if err != nil {
+		t.Fatalf("downloadImage returned an error for application/octet-stream: %s", err)
+	}
+
+	for _, path := range []string{"/html.webp", "/large.webp", "/text.webp", "/missing.webp"} {
+		filePath := filepath.Join(imageDirectory, "failed.webp")
+		err := newTestClient(t).downloadImage(context.Background(), filePath, server.URL+path)
+		if err == nil {
+			t.Errorf("expected error downloading %s", path)
+		}
+		if utility.FileExists(filePath) {
+			t.Errorf("expected no image file after failed download of %s", path)
+		}
+	}
+
+	entries, _ := os.ReadDir(imageDirectory)
+	if len(entries) != 2 {
+		t.Errorf("expected only downloaded image in image directory, got %d files", len(entries))
+	}
+}
+
+func TestEnsurePostImagesExistInParallel(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	arrived := make(chan struct{}, 8)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.webp" {
+			// the response never arrives before the client gives up
+			<-r.Context().Done()
+			return
+		}
+
+		mu.Lock()
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+
+		// downloads are held until the test has seen enough of them in parallel
+		arrived <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "))
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+	var releaseOnce sync.Once
+	releaseAll := func() { releaseOnce.Do(func() { close(release) }) }
+	defer releaseAll()
+
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	t.Setenv("BHP_IMAGE_CONCURRENCY", "3")
+
+	posts := make([]Post, 8)
+	for i := range posts {
+		posts[i].ID = fmt.Sprintf("post%d", i)
+		posts[i].Sizes.Small.externalURL = server.URL + "/" + posts[i].ID + ".webp"
+	}
+
+	database := newTestDB(t)
+	client := newTestClient(t)
+	done := make(chan error)
+	go func() {
+		_, err := ensurePostImagesExist(database, client, posts, AllMedia)
+		done <- err
+	}()
+
+	for i := range 3 {
+		select {
+		case <-arrived:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected 3 parallel downloads, got %d", i)
+		}
+	}
+	releaseAll()
+	if err := <-done; err != nil {
+		t.Fatalf("ensurePostImagesExist returned an error: %s", err)
+	}
+
+	for _, post := range posts {
+		if !utility.FileExists(filepath.Join(imageDirectory, post.ID+".webp")) {
+			t.Errorf("expected image of %s to be downloaded", post.ID)
+		}
+		if post.Sizes.Small.Url != "/"+post.ID+".webp" {
+			t.Errorf("unexpected url %s for %s", post.Sizes.Small.Url, post.ID)
+		}
+	}
+	mu.Lock()
+	if maxActive != 3 {
+		t.Errorf("expected 3 parallel downloads, got %d", maxActive)
+	}
+	mu.Unlock()
+
+	t.Setenv("BHP_IMAGE_TIMEOUT", "100ms")
+	slow := []Post{{ID: "slow", Sizes: Sizes{Small: Media{externalURL: server.URL + "/slow.webp"}}}}
+	temporary, err := ensurePostImagesExist(database, newTestClient(t), slow, AllMedia)
+	if err != nil {
+		t.Fatalf("ensurePostImagesExist returned an error: %s", err)
+	}
+	if slow[0].Sizes.Small.Url != server.URL+"/slow.webp" || utility.FileExists(filepath.Join(imageDirectory, "slow.webp")) {
+		t.Errorf("expected external URL for download exceeding timeout, got %s", slow[0].Sizes.Small.Url)
+	}
+	if !temporary {
+		t.Errorf("expected external URL to be reported as temporary")
+	}
+}
+
+func TestEnsurePostImagesExistWithFailedDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/thumbnail.jpg":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"))
+		case "/small.webp":
+			w.Header().Set("Content-Type", "image/webp")
+			w.Write([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	t.Setenv("BHP_IMAGE_URL", "/images")
+
+	posts := []Post{{
+		ID:                   "post1",
+		Sizes:                Sizes{Small: Media{externalURL: server.URL + "/small.webp"}, Large: Media{externalURL: server.URL + "/missing.webp"}},
+		thumbnailExternalURL: server.URL + "/thumbnail.jpg",
+		videoExternalURL:     "https://example.com/video.mp4",
+	}}
+
+	_, err := ensurePostImagesExist(newTestDB(t), newTestClient(t), posts, AllMedia)
+	if err != nil {
+		t.Fatalf("expected failed media files not to fail the feed: %s", err)
+	}
+	if posts[0].Sizes.Small.Url != "/images/post1.webp" || posts[0].ThumbnailUrl != "/images/post1-thumbnail.jpg" {
+		t.Errorf("expected internal URLs of downloaded files, got %+v", posts[0])
+	}
+	if posts[0].Sizes.Large.Url != server.URL+"/missing.webp" {
+		t.Errorf("expected external URL of failed download, got %s", posts[0].Sizes.Large.Url)
+	}
+	if posts[0].VideoUrl != "" {
+		t.Errorf("expected no URL for media from a host which is not allowed, got %s", posts[0].VideoUrl)
+	}
+}
+
+func TestEnsurePostImagesExistWhileLocked(t *testing.T) {
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	t.Setenv("BHP_IMAGE_URL", "/images")
+	t.Setenv("BHP_LOCK_WAIT", "100ms")
+
+	// another process is downloading the image for longer than BHP_LOCK_WAIT
+	database := newTestDB(t)
+	_, err := database.Exec(`INSERT INTO locks (name, holder, expires_at) VALUES (?, ?, ?)`,
+		"image:post1.webp", "other", time.Now().Add(time.Minute).UnixNano())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := []Post{{ID: "post1", Sizes: Sizes{Small: Media{externalURL: "https://behold.pictures/post1.webp"}}}}
+	temporary, err := ensurePostImagesExist(database, newTestClient(t), posts, AllMedia)
+	if err != nil {
+		t.Fatalf("ensurePostImagesExist returned an error: %s", err)
+	}
+	if posts[0].Sizes.Small.Url != "https://behold.pictures/post1.webp" || !temporary {
+		t.Errorf("expected temporary external URL until the image exists, got %s", posts[0].Sizes.Small.Url)
+	}
+}
+
+func TestEnsurePostImagesExistSmallImages(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "))
+	}))
+	defer server.Close()
+
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	t.Setenv("BHP_IMAGE_URL", "/images")
+	t.Setenv("BHP_IMAGE_CONCURRENCY", "1")
+
+	posts := []Post{{
+		ID: "post1",
+		Sizes: Sizes{
+			Small: Media{externalURL: server.URL + "/small.webp"},
+			Large: Media{externalURL: server.URL + "/large.webp"},
+		},
+		Children: []Child{{ID: "child1", Sizes: Sizes{Small: Media{externalURL: server.URL + "/child.webp"}}}},
+	}}
+
+	_, err := ensurePostImagesExist(newTestDB(t), newTestClient(t), posts, SmallImages)
+	if err != nil {
+		t.Fatalf("ensurePostImagesExist returned an error: %s", err)
+	}
+	if !slices.Equal(requested, []string{"/small.webp"}) {
+		t.Errorf("expected only the small image to be downloaded, got %v", requested)
+	}
+	if posts[0].Sizes.Small.Url != "/images/post1.webp" || posts[0].Sizes.Large.Url != "" || posts[0].Children[0].Sizes.Small.Url != "" {
+		t.Errorf("expected only the url of the small image, got %+v", posts[0])
+	}
+}
@@ -0,0 +1,121 @@
+package feed
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// feedLockTTL is how long a feed refresh may take before other processes may take over
+	feedLockTTL = 2 * time.Minute
+	// imageLockTTL is how long an image download may take before other processes may take over
+	imageLockTTL = time.Minute
+	// defaultLockWait is used when BHP_LOCK_WAIT is not set
+	defaultLockWait = 10 * time.Second
+	// lockPollInterval is how often a held lock is retried
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// errLockTimeout means that another process held the lock for longer than BHP_LOCK_WAIT
+var errLockTimeout = errors.New("timed out waiting for lock")
+
+// errLockNotNeeded means that the work guarded by the lock was done by another process
+var errLockNotNeeded = errors.New("lock not needed")
+
+// getLockWait returns how long processes wait for others to refresh feeds and download images
+func getLockWait() (time.Duration, error) {
+	waitStr := os.Getenv("BHP_LOCK_WAIT")
+	if waitStr == "" {
+		return defaultLockWait, nil
+	}
+
+	wait, err := time.ParseDuration(waitStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BHP_LOCK_WAIT %s: %w", waitStr, err)
+	}
+
+	return wait, nil
+}
+
+// waitForLock takes the named lock shared by all processes using the database. While
+// waiting, done is called to check whether the lock holder already did the work, in
+// which case errLockNotNeeded is returned. Locks of crashed processes expire after ttl.
+func waitForLock(db *sql.DB, name string, ttl time.Duration, done func() bool) (func(), error) {
+	wait, err := getLockWait()
+	if err != nil {
+		return nil, err
+	}
+
+	holder, err := newLockHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		acquired, err := tryLock(db, name, holder, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take lock %s: %w", name, err)
+		}
+
+		if acquired {
+			unlock := func() {
+				_, err := db.Exec(`DELETE FROM locks WHERE name = ? AND holder = ?`, name, holder)
+				if err != nil {
+					log.Printf("failed to release lock %s: %s", name, err)
+				}
+			}
+			// the work may have been completed just before the lock was taken
+			if done() {
+				unlock()
+				return nil, errLockNotNeeded
+			}
+			return unlock, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+		if done() {
+			return nil, errLockNotNeeded
+		}
+	}
+}
+
+// tryLock takes the named lock if it is free or expired
+func tryLock(db *sql.DB, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := db.Exec(
+		`INSERT INTO locks (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+		holder = excluded.holder,
+		expires_at = excluded.expires_at
+		WHERE locks.expires_at < ?;`,
+		name, holder, now.Add(ttl).UnixNano(), now.UnixNano(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// newLockHolder returns a random ID identifying the lock holder
+func newLockHolder() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock holder id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,58 @@
+package feed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForLock(t *testing.T) {
+	database := newTestDB(t)
+	t.Setenv("BHP_LOCK_WAIT", "500ms")
+	notDone := func() bool { return false }
+
+	unlock, err := waitForLock(database, "feed:feed1", time.Minute, notDone)
+	if err != nil {
+		t.Fatalf("waitForLock returned an error for free lock: %s", err)
+	}
+
+	_, err = waitForLock(database, "feed:feed1", time.Minute, notDone)
+	if !errors.Is(err, errLockTimeout) {
+		t.Errorf("expected errLockTimeout for held lock, got %v", err)
+	}
+
+	_, err = waitForLock(database, "feed:feed1", time.Minute, func() bool { return true })
+	if !errors.Is(err, errLockNotNeeded) {
+		t.Errorf("expected errLockNotNeeded when work is done by lock holder, got %v", err)
+	}
+
+	otherUnlock, err := waitForLock(database, "feed:feed2", time.Minute, notDone)
+	if err != nil {
+		t.Errorf("waitForLock returned an error for another lock: %s", err)
+	} else {
+		otherUnlock()
+	}
+
+	unlock()
+	unlock, err = waitForLock(database, "feed:feed1", time.Minute, notDone)
+	if err != nil {
+		t.Fatalf("waitForLock returned an error for released lock: %s", err)
+	}
+	unlock()
+}
+
+func TestWaitForExpiredLock(t *testing.T) {
+	database := newTestDB(t)
+
+	_, err := database.Exec(`INSERT INTO locks (name, holder, expires_at) VALUES (?, ?, ?)`,
+		"image:post1.webp", "crashed", time.Now().Add(-time.Second).UnixNano())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := waitForLock(database, "image:post1.webp", time.Minute, func() bool { return false })
+	if err != nil {
+		t.Fatalf("expected expired lock to be taken over, got %s", err)
+	}
+	unlock()
+}
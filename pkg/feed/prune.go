@@ -0,0 +1,166 @@
+package feed
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PruneReport lists what PruneFeed removed from a feed
+type PruneReport struct {
+	FeedID       string
+	PostIDs      []string
+	ChildIDs     []string
+	RemovedFiles []string
+}
+
+// PruneFeed removes posts exceeding the post count of the feed from the database
+// together with their carousel children and media files
+func PruneFeed(db *sql.DB, feedID string) (PruneReport, error) {
+	report := PruneReport{FeedID: feedID}
+
+	imageDirectory, err := getImageDirectory()
+	if err != nil {
+		return report, fmt.Errorf("could not remove deprecated posts: %w", err)
+	}
+
+	postCount, err := getPostCount(feedID)
+	if err != nil {
+		return report, fmt.Errorf("failed to get post count: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	report.PostIDs, err = getIrrelevantPosts(tx, feedID, postCount)
+	if err != nil {
+		return report, fmt.Errorf("error getting post ids for feed %s: %w", feedID, err)
+	}
+	// if feed has no irrelevant posts, there is nothing to remove
+	if len(report.PostIDs) == 0 {
+		err = tx.Commit()
+		return report, err
+	}
+
+	report.ChildIDs, err = queryIDs(tx, `SELECT child_id FROM post_children WHERE post_id IN (%s)`, report.PostIDs)
+	if err != nil {
+		return report, fmt.Errorf("error getting children of deprecated posts: %w", err)
+	}
+
+	err = execWithIDs(tx, `DELETE FROM post_children WHERE post_id IN (%s)`, report.PostIDs)
+	if err != nil {
+		return report, fmt.Errorf("error deleting children for feed %s: %w", feedID, err)
+	}
+	err = execWithIDs(tx, `DELETE FROM posts WHERE post_id IN (%s)`, report.PostIDs)
+	if err != nil {
+		return report, fmt.Errorf("error deleting posts for feed %s: %w", feedID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return report, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// files are removed only after the posts are gone so that no served post lacks its files
+	for _, mediaID := range append(report.PostIDs, report.ChildIDs...) {
+		for _, fileName := range mediaFileNames(mediaID) {
+			filePath := filepath.Join(imageDirectory, fileName)
+			// check if file already doesn't exist
+			if _, err := os.Stat(filePath); err != nil {
+				continue
+			}
+			// if file exists, it is removed
+			if err := os.Remove(filePath); err != nil {
+				log.Printf("error removing file %s: %s", filePath, err)
+				continue
+			}
+			report.RemovedFiles = append(report.RemovedFiles, fileName)
+		}
+	}
+
+	return report, nil
+}
+
+// PruneAllFeeds runs PruneFeed for every feed in the database
+func PruneAllFeeds(db *sql.DB) ([]PruneReport, error) {
+	rows, err := db.Query(`SELECT feed_id FROM feeds ORDER BY feed_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying feeds: %w", err)
+	}
+	feedIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning feed ids: %w", err)
+	}
+
+	reports := make([]PruneReport, 0, len(feedIDs))
+	for _, feedID := range feedIDs {
+		report, err := PruneFeed(db, feedID)
+		if err != nil {
+			return reports, fmt.Errorf("failed to prune feed %s: %w", feedID, err)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// getIrrelevantPosts returns the IDs of all irrelevant (very old) posts that belong to the feed
+func getIrrelevantPosts(tx *sql.Tx, feedID string, postCount int) ([]string, error) {
+	// skip the most recent posts as they are still relevant
+	query := `SELECT post_id FROM posts WHERE feed_id = ? ORDER BY timestamp DESC, post_id LIMIT -1 OFFSET ?;`
+	rows, err := tx.Query(query, feedID, postCount)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching irrelevant posts from feed: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+// placeholders returns comma-separated query placeholders for the ids and the
+// ids as query arguments
+func placeholders(ids []string) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), args
+}
+
+// queryIDs runs query having %s in place of the IN list and returns the IDs it selects
+func queryIDs(tx *sql.Tx, query string, ids []string) ([]string, error) {
+	inList, args := placeholders(ids)
+	rows, err := tx.Query(fmt.Sprintf(query, inList), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// execWithIDs runs statement having %s in place of the IN list
+func execWithIDs(tx *sql.Tx, query string, ids []string) error {
+	inList, args := placeholders(ids)
+	_, err := tx.Exec(fmt.Sprintf(query, inList), args...)
+	return err
+}
+
+// scanIDs reads single column ID rows and closes them
+func scanIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning ID from row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,105 @@
+package feed
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPruneFeed(t *testing.T) {
+	database := newTestDB(t)
+	insertTestFeed(t, database, "feed1", time.Now().UTC())
+	insertTestPosts(t, database, "feed1", 15)
+	t.Setenv("BHP_POST_COUNT", "12")
+
+	imageDirectory := t.TempDir()
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	for _, fileName := range []string{"post00.webp", "post00-large.webp", "child1.webp", "post14.webp"} {
+		err := os.WriteFile(filepath.Join(imageDirectory, fileName), []byte("image"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := database.Exec(`INSERT INTO post_children (child_id, post_id, position) VALUES (?, ?, ?)`, "child1", "post00", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := PruneFeed(database, "feed1")
+	if err != nil {
+		t.Fatalf("PruneFeed returned an error: %s", err)
+	}
+
+	if len(report.PostIDs) != 3 || !slices.Contains(report.PostIDs, "post00") || slices.Contains(report.PostIDs, "post14") {
+		t.Errorf("expected 3 oldest posts to be pruned, got %v", report.PostIDs)
+	}
+	if !slices.Equal(report.ChildIDs, []string{"child1"}) {
+		t.Errorf("expected child1 to be pruned, got %v", report.ChildIDs)
+	}
+	slices.Sort(report.RemovedFiles)
+	if !slices.Equal(report.RemovedFiles, []string{"child1.webp", "post00-large.webp", "post00.webp"}) {
+		t.Errorf("unexpected removed files %v", report.RemovedFiles)
+	}
+
+	var postCount, childCount int
+	database.QueryRow(`SELECT COUNT(*) FROM posts WHERE feed_id = ?`, "feed1").Scan(&postCount)
+	database.QueryRow(`SELECT COUNT(*) FROM post_children`).Scan(&childCount)
+	if postCount != 12 || childCount != 0 {
+		t.Errorf("expected 12 posts and no children left, got %d and %d", postCount, childCount)
+	}
+	if _, err := os.Stat(filepath.Join(imageDirectory, "post14.webp")); err != nil {
+		t.Errorf("expected image of relevant post to be kept: %s", err)
+	}
+
+	reports, err := PruneAllFeeds(database)
+	if err != nil {
+		t.Fatalf("PruneAllFeeds returned an error: %s", err)
+	}
+	if len(reports) != 1 || len(reports[0].PostIDs) != 0 {
+		t.Errorf("expected nothing left to prune, got %+v", reports)
+	}
+}
+
+func TestPruneFeedWithUnsafePostID(t *testing.T) {
+	database := newTestDB(t)
+	insertTestFeed(t, database, "feed1", time.Now().UTC())
+	insertTestPosts(t, database, "feed1", 1)
+	t.Setenv("BHP_POST_COUNT", "1")
+
+	// the oldest post has an ID trying to escape the image directory
+	_, err := database.Exec(
+		`INSERT INTO posts
+		(post_id, feed_id, permalink, timestamp, media_type, media_small_url, media_small_height, media_small_width, caption, pruned_caption)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		"../victim", "feed1", "", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "IMAGE", "", 0, 0, "", "",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parentDirectory := t.TempDir()
+	imageDirectory := filepath.Join(parentDirectory, "images")
+	t.Setenv("BHP_IMAGE_DIRECTORY", imageDirectory)
+	for _, filePath := range []string{filepath.Join(parentDirectory, "victim.webp"), filepath.Join(imageDirectory, mediaFileBase("../victim")+".webp")} {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := PruneFeed(database, "feed1")
+	if err != nil {
+		t.Fatalf("PruneFeed returned an error: %s", err)
+	}
+
+	if !slices.Equal(report.PostIDs, []string{"../victim"}) || len(report.RemovedFiles) != 1 {
+		t.Errorf("expected the unsafe post and its file to be pruned, got %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(parentDirectory, "victim.webp")); err != nil {
+		t.Errorf("expected file outside image directory to be kept: %s", err)
+	}
+}
@@ -0,0 +1,173 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultMediaHosts is used when BHP_MEDIA_ALLOWED_HOSTS is not set. Behold serves
+	// images from its CDN while videos are served from Instagram CDN.
+	defaultMediaHosts = "behold.pictures,cdninstagram.com,fbcdn.net"
+	// maxMediaRedirects is how many redirects are followed when downloading media
+	maxMediaRedirects = 3
+	// defaultDialTimeout matches the dial timeout of http.DefaultTransport
+	defaultDialTimeout = 30 * time.Second
+)
+
+// ErrMediaNotAllowed means that the media URL points to a host media is not downloaded from
+var ErrMediaNotAllowed = errors.New("media host not allowed")
+
+// nonPublicPrefixes lists address ranges not covered by netip.Addr methods which
+// must not be reached from media URLs
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// getMediaHosts returns the host suffixes media may be downloaded from
+func getMediaHosts() []string {
+	hostsStr := os.Getenv("BHP_MEDIA_ALLOWED_HOSTS")
+	if hostsStr == "" {
+		hostsStr = defaultMediaHosts
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(hostsStr, ",") {
+		if host = strings.ToLower(strings.Trim(strings.TrimSpace(host), ".")); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// checkMediaURL returns ErrMediaNotAllowed unless mediaURL is a HTTP(S) URL on one
+// of the hosts or their subdomains
+func checkMediaURL(mediaURL *url.URL, hosts []string) error {
+	if mediaURL.Scheme != "https" && mediaURL.Scheme != "http" {
+		return fmt.Errorf("%w: scheme %s", ErrMediaNotAllowed, mediaURL.Scheme)
+	}
+
+	hostname := strings.ToLower(strings.TrimSuffix(mediaURL.Hostname(), "."))
+	for _, host := range hosts {
+		if hostname == host || strings.HasSuffix(hostname, "."+host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrMediaNotAllowed, hostname)
+}
+
+// isPublicAddr reports whether addr may be connected to when downloading media
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// refuseNonPublicAddr is a net.Dialer Control function. It is called after DNS
+// resolution, so host names resolving to internal addresses are refused as well.
+func refuseNonPublicAddr(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %s", ErrMediaNotAllowed, address)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s is not a public address", ErrMediaNotAllowed, addrPort.Addr())
+	}
+	return nil
+}
+
+// checkPublicHost returns ErrMediaNotAllowed if host resolves to any address which is not public
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return fmt.Errorf("%w: %s resolves to %s which is not a public address", ErrMediaNotAllowed, host, addr)
+		}
+	}
+	return nil
+}
+
+// newMediaHTTPClient returns a HTTP client for downloading media which connects only to
+// public addresses and follows redirects only to the allowed hosts.
+//
+// Requests sent through a HTTP proxy are checked by resolving the media host before the
+// request is passed to the proxy. The proxy resolves the host again, so only a proxy
+// refusing internal addresses itself protects against DNS rebinding. Connections to the
+// proxy are allowed even if the proxy has an internal address.
+func newMediaHTTPClient(transport *http.Transport, hosts []string, allowPrivate bool) *http.Client {
+	transport = transport.Clone()
+
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultDialTimeout}
+		guardedDialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultDialTimeout, Control: refuseNonPublicAddr}
+
+		// addresses of the proxies chosen for requests, e.g. NO_PROXY may leave some hosts without a proxy
+		var proxyAddrs sync.Map
+		if proxy := transport.Proxy; proxy != nil {
+			transport.Proxy = func(req *http.Request) (*url.URL, error) {
+				proxyURL, err := proxy(req)
+				if err != nil || proxyURL == nil {
+					return proxyURL, err
+				}
+				if err := checkPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+					return nil, err
+				}
+				proxyAddrs.Store(proxyAddr(proxyURL), true)
+				return proxyURL, nil
+			}
+		}
+
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			if _, ok := proxyAddrs.Load(address); ok {
+				return dialer.DialContext(ctx, network, address)
+			}
+			return guardedDialer.DialContext(ctx, network, address)
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxMediaRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxMediaRedirects)
+			}
+			return checkMediaURL(req.URL, hosts)
+		},
+	}
+}
+
+// proxyAddr returns the address the transport dials to connect to the proxy
+func proxyAddr(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[proxyURL.Scheme]
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
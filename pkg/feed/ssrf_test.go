@@ -0,0 +1,152 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckMediaURL(t *testing.T) {
+	hosts := []string{"behold.pictures", "cdninstagram.com"}
+
+	allowed := []string{
+		"https://behold.pictures/abc/small.webp",
+		"https://BEHOLD.pictures./abc/small.webp",
+		"https://scontent-hel3-1.cdninstagram.com/v/video.mp4",
+	}
+	for _, mediaURL := range allowed {
+		parsedURL, _ := url.Parse(mediaURL)
+		if err := checkMediaURL(parsedURL, hosts); err != nil {
+			t.Errorf("expected %s to be allowed, got %s", mediaURL, err)
+		}
+	}
+
+	refused := []string{
+		"https://evil.com/small.webp",
+		"https://behold.pictures.evil.com/small.webp",
+		"https://notbehold.pictures/small.webp",
+		"http://169.254.169.254/latest/meta-data/",
+		"file:///etc/passwd",
+		"gopher://behold.pictures/",
+	}
+	for _, mediaURL := range refused {
+		parsedURL, _ := url.Parse(mediaURL)
+		if err := checkMediaURL(parsedURL, hosts); !errors.Is(err, ErrMediaNotAllowed) {
+			t.Errorf("expected %s to be refused, got %v", mediaURL, err)
+		}
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	public := []string{"8.8.8.8", "151.101.1.1", "2a00:1450:4001:80b::200e"}
+	for _, addr := range public {
+		if !isPublicAddr(netip.MustParseAddr(addr)) {
+			t.Errorf("expected %s to be public", addr)
+		}
+	}
+
+	internal := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0",
+		"100.64.0.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "::ffff:10.0.0.1",
+	}
+	for _, addr := range internal {
+		if isPublicAddr(netip.MustParseAddr(addr)) {
+			t.Errorf("expected %s to be refused", addr)
+		}
+	}
+}
+
+func TestDownloadImageRefusesInternalTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://evil.example/image.webp", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	// localhost is an allowed host but resolves to loopback
+	t.Setenv("BHP_MEDIA_ALLOWED_HOSTS", "localhost")
+	t.Setenv("BHP_RETRY_MAX", "0")
+	client, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "post1.webp")
+	err = client.downloadImage(context.Background(), filePath, "http://localhost:"+serverURL.Port()+"/image.webp")
+	if !errors.Is(err, ErrMediaNotAllowed) || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("expected loopback address to be refused, got %v", err)
+	}
+
+	client = newTestClient(t)
+	err = client.downloadImage(context.Background(), filePath, server.URL+"/redirect")
+	if !errors.Is(err, ErrMediaNotAllowed) {
+		t.Errorf("expected redirect to other host to be refused, got %v", err)
+	}
+
+	err = client.downloadImage(context.Background(), filePath, server.URL+"/loop")
+	if err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("expected redirect loop to be stopped, got %v", err)
+	}
+}
+
+func TestMediaHTTPClientChecksTargetsBehindProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+	}))
+	defer proxy.Close()
+
+	t.Setenv("BHP_MEDIA_ALLOWED_HOSTS", "localhost,203.0.113.10")
+	t.Setenv("BHP_HTTP_PROXY", proxy.URL)
+	t.Setenv("BHP_RETRY_MAX", "0")
+	client, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "post1.webp")
+	err = client.downloadImage(context.Background(), filePath, "http://localhost/image.webp")
+	if !errors.Is(err, ErrMediaNotAllowed) || len(proxied) != 0 {
+		t.Errorf("expected loopback target to be refused before the proxy, got %v and %v", err, proxied)
+	}
+
+	// the proxy itself has a loopback address
+	err = client.downloadImage(context.Background(), filePath, "http://203.0.113.10/image.webp")
+	if err != nil || len(proxied) != 1 {
+		t.Errorf("expected public target to be downloaded through the proxy, got %v and %v", err, proxied)
+	}
+
+	// hosts excluded from the proxy are connected directly and checked when dialing
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) {
+		if req.URL.Hostname() == "localhost" {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}}
+	httpClient := newMediaHTTPClient(transport, []string{"localhost"}, false)
+	if _, err := httpClient.Get("http://203.0.113.10/image.webp"); err != nil {
+		t.Fatalf("expected request through the proxy to succeed, got %v", err)
+	}
+	_, err = httpClient.Get("http://localhost:" + proxyURL.Port() + "/image.webp")
+	if !errors.Is(err, ErrMediaNotAllowed) || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("expected direct connection to loopback to be refused, got %v", err)
+	}
+}
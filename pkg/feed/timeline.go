@@ -0,0 +1,82 @@
+package feed
+
+import (
+	"slices"
+	"time"
+)
+
+// Timeline combines the posts of several feeds, e.g. all accounts of a client
+type Timeline struct {
+	Feeds     []TimelineFeed `json:"feeds"`
+	Posts     []TimelinePost `json:"posts"`
+	FetchedAt time.Time      `json:"fetchedAt"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+	// MissingFeedIDs lists the feeds which could not be served
+	MissingFeedIDs []string `json:"missingFeedIds,omitempty"`
+
+	stale          bool
+	temporaryMedia bool
+}
+
+// TimelineFeed is a source feed of the timeline
+type TimelineFeed struct {
+	ID                string `json:"id"`
+	Username          string `json:"username"`
+	ProfilePictureUrl string `json:"profilePictureUrl"`
+}
+
+// TimelinePost is a post of the timeline together with its source feed
+type TimelinePost struct {
+	Post
+	FeedID   string `json:"feedId"`
+	Username string `json:"username"`
+}
+
+// MergeFeeds merges the posts of the feeds from newest to oldest. Posts appearing in
+// several feeds are included only once. At most limit posts are returned, zero means
+// all posts. The timeline was fetched when its most recently fetched feed was and
+// expires when the first of the feeds expires.
+func MergeFeeds(feeds []*Feed, limit int) *Timeline {
+	timeline := &Timeline{Feeds: []TimelineFeed{}, Posts: []TimelinePost{}}
+	seen := map[string]bool{}
+
+	for _, f := range feeds {
+		timeline.Feeds = append(timeline.Feeds, TimelineFeed{ID: f.ID, Username: f.Username, ProfilePictureUrl: f.ProfilePictureUrl})
+
+		if f.FetchedAt.After(timeline.FetchedAt) {
+			timeline.FetchedAt = f.FetchedAt
+		}
+		if timeline.ExpiresAt.IsZero() || f.ExpiresAt.Before(timeline.ExpiresAt) {
+			timeline.ExpiresAt = f.ExpiresAt
+		}
+		timeline.stale = timeline.stale || f.IsStale()
+		timeline.temporaryMedia = timeline.temporaryMedia || f.HasTemporaryMedia()
+
+		for _, post := range f.Posts {
+			if seen[post.ID] {
+				continue
+			}
+			seen[post.ID] = true
+			timeline.Posts = append(timeline.Posts, TimelinePost{Post: post, FeedID: f.ID, Username: f.Username})
+		}
+	}
+
+	slices.SortStableFunc(timeline.Posts, func(a, b TimelinePost) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+	if limit > 0 && len(timeline.Posts) > limit {
+		timeline.Posts = timeline.Posts[:limit]
+	}
+
+	return timeline
+}
+
+// IsStale reports whether any of the feeds was served from stale cache
+func (t *Timeline) IsStale() bool {
+	return t.stale
+}
+
+// HasTemporaryMedia reports whether any of the feeds has media served from its external source
+func (t *Timeline) HasTemporaryMedia() bool {
+	return t.temporaryMedia
+}
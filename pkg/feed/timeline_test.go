@@ -0,0 +1,58 @@
+package feed
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMergeFeeds(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	now := time.Now()
+
+	feeds := []*Feed{
+		{
+			ID: "feed1", Username: "first", FetchedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour),
+			Posts: []Post{{ID: "a", Timestamp: day(5)}, {ID: "shared", Timestamp: day(3)}, {ID: "b", Timestamp: day(1)}},
+		},
+		{
+			ID: "feed2", Username: "second", FetchedAt: now, ExpiresAt: now.Add(2 * time.Hour), stale: true,
+			Posts: []Post{{ID: "c", Timestamp: day(4)}, {ID: "shared", Timestamp: day(3)}, {ID: "d", Timestamp: day(2)}},
+		},
+	}
+
+	timeline := MergeFeeds(feeds, 0)
+
+	var ids, usernames []string
+	for _, post := range timeline.Posts {
+		ids = append(ids, post.ID)
+		usernames = append(usernames, post.Username)
+	}
+	if !slices.Equal(ids, []string{"a", "c", "shared", "d", "b"}) {
+		t.Errorf("expected posts merged by timestamp without duplicates, got %v", ids)
+	}
+	if !slices.Equal(usernames, []string{"first", "second", "first", "second", "first"}) {
+		t.Errorf("unexpected source usernames %v", usernames)
+	}
+	if !timeline.FetchedAt.Equal(now) || !timeline.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected newest fetch time and earliest expiry, got %s and %s", timeline.FetchedAt, timeline.ExpiresAt)
+	}
+	if !timeline.IsStale() || len(timeline.Feeds) != 2 {
+		t.Errorf("expected stale timeline of 2 feeds, got %+v", timeline)
+	}
+
+	if limited := MergeFeeds(feeds, 2); len(limited.Posts) != 2 || limited.Posts[1].ID != "c" {
+		t.Errorf("expected 2 newest posts, got %+v", limited.Posts)
+	}
+
+	body, err := json.Marshal(timeline.Posts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var post map[string]any
+	json.Unmarshal(body, &post)
+	if post["id"] != "a" || post["feedId"] != "feed1" || post["username"] != "first" {
+		t.Errorf("expected post fields together with source feed, got %s", body)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+// cacheEntry describes the cached data a response is rendered from
+type cacheEntry struct {
+	// key identifies the data in the compression cache, e.g. feed ID
+	key       string
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+// feedCacheEntry returns the cache entry of responses rendered from the feed
+func feedCacheEntry(f *feed.Feed) cacheEntry {
+	entry := cacheEntry{key: f.ID, fetchedAt: f.FetchedAt, expiresAt: f.ExpiresAt}
+	if f.HasTemporaryMedia() {
+		// external media URLs are replaced once the files are downloaded and may expire
+		entry.expiresAt = time.Now()
+	}
+	return entry
+}
+
+// writeCachedResponse writes the encoded response with caching headers derived from the
+// feed cache. Requests whose validators match get 304 Not Modified without a body.
+// The body is compressed if the client accepts it, see writeEncodedBody.
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, db *sql.DB, entry cacheEntry, body []byte) {
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), len(body))
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// each encoding is a different representation and needs its own entity tag
+	etag := computeETag(body, encoding)
+	lastModified := entry.fetchedAt.UTC().Truncate(time.Second)
+
+	// browsers and proxies may cache the response until the feed cache expires
+	maxAge := max(int(time.Until(entry.expiresAt).Seconds()), 0)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+
+	if notModified(r, etag, lastModified) {
+		// representation headers are not sent with 304 responses
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeEncodedBody(w, body, encoding, func(encoding string, body []byte) ([]byte, error) {
+		return getEncodedBody(db, entry, etag, encoding, body)
+	})
+}
+
+// computeETag returns a strong entity tag for the response body in the given encoding
+func computeETag(body []byte, encoding string) string {
+	sum := sha256.Sum256(body)
+	if encoding != "" {
+		return `"` + hex.EncodeToString(sum[:16]) + "-" + encoding + `"`
+	}
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the client already has the current response. As in
+// RFC 9110, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.After(since)
+	}
+
+	return false
+}
+
+// etagMatches compares etag weakly to the comma-separated If-None-Match list
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
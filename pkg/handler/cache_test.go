@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func TestWriteCachedResponse(t *testing.T) {
+	fetchedAt := time.Date(2025, 1, 29, 18, 34, 9, 0, time.UTC)
+	f := &feed.Feed{ID: "1234", FetchedAt: fetchedAt, ExpiresAt: time.Now().Add(time.Hour)}
+	body := []byte(`{"id":"1234"}`)
+
+	rec := httptest.NewRecorder()
+	writeCachedResponse(rec, httptest.NewRequest(http.MethodGet, "/?id=1234", nil), nil, feedCacheEntry(f), body)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != string(body) {
+		t.Fatalf("expected 200 with body, got %d %q", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) {
+		t.Errorf("expected strong etag, got %s", etag)
+	}
+	if rec.Header().Get("Last-Modified") != "Wed, 29 Jan 2025 18:34:09 GMT" {
+		t.Errorf("unexpected Last-Modified %s", rec.Header().Get("Last-Modified"))
+	}
+	cacheControl := rec.Header().Get("Cache-Control")
+	if cacheControl != "public, max-age=3599" && cacheControl != "public, max-age=3600" {
+		t.Errorf("expected max-age of about an hour, got %s", cacheControl)
+	}
+
+	conditionalRequests := map[string]http.Header{
+		"matching etag":      {"If-None-Match": {`"other", ` + etag}},
+		"weak matching etag": {"If-None-Match": {"W/" + etag}},
+		"not modified since": {"If-Modified-Since": {"Wed, 29 Jan 2025 18:34:09 GMT"}},
+	}
+	for name, header := range conditionalRequests {
+		req := httptest.NewRequest(http.MethodGet, "/?id=1234", nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		writeCachedResponse(rec, req, nil, feedCacheEntry(f), body)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: expected 304 without body, got %d %q", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	modifiedRequests := map[string]http.Header{
+		"other etag":     {"If-None-Match": {`"other"`}},
+		"modified since": {"If-Modified-Since": {"Tue, 28 Jan 2025 18:34:09 GMT"}},
+		"etag overrides date": {
+			"If-None-Match":     {`"other"`},
+			"If-Modified-Since": {"Wed, 29 Jan 2025 18:34:09 GMT"},
+		},
+	}
+	for name, header := range modifiedRequests {
+		req := httptest.NewRequest(http.MethodGet, "/?id=1234", nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		writeCachedResponse(rec, req, nil, feedCacheEntry(f), body)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", name, rec.Code)
+		}
+	}
+
+	f.ExpiresAt = time.Now().Add(-time.Hour)
+	rec = httptest.NewRecorder()
+	writeCachedResponse(rec, httptest.NewRequest(http.MethodGet, "/?id=1234", nil), nil, feedCacheEntry(f), body)
+	if rec.Header().Get("Cache-Control") != "public, max-age=0" {
+		t.Errorf("expected max-age=0 for expired feed, got %s", rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestHandleGetFeedWithTemporaryMedia(t *testing.T) {
+	available := false
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/webp")
+		w.Write([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "))
+	}))
+	defer media.Close()
+	behold := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"username": "user", "posts": [{"id": "post1", "timestamp": "2025-01-01T00:00:00+0000",
+			"mediaType": "IMAGE", "sizes": {"small": {"mediaUrl": "%s/post1.webp"}}}]}`, media.URL)
+	}))
+	defer behold.Close()
+
+	t.Setenv("BHP_BEHOLD_BASE_URL", behold.URL)
+	t.Setenv("BHP_IMAGE_DIRECTORY", t.TempDir())
+	t.Setenv("BHP_IMAGE_URL", "/images")
+	t.Setenv("BHP_ALLOWED_FEED_IDS", "")
+	t.Setenv("BHP_MEDIA_ALLOWED_HOSTS", "127.0.0.1")
+	t.Setenv("BHP_MEDIA_ALLOW_PRIVATE_IPS", "true")
+	h, err := NewSqliteHandler(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetFeed(rec, httptest.NewRequest(http.MethodGet, "/?id=1234&format=rss", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), media.URL+"/post1.webp") {
+		t.Fatalf("expected feed with external media URL, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=0" {
+		t.Errorf("expected max-age=0 for temporary media URL, got %s", rec.Header().Get("Cache-Control"))
+	}
+
+	available = true
+	rec = httptest.NewRecorder()
+	h.HandleGetFeed(rec, httptest.NewRequest(http.MethodGet, "/?id=1234&format=rss", nil))
+	if !strings.Contains(rec.Body.String(), "/images/post1.webp") {
+		t.Fatalf("expected internal media URL once downloaded, got %s", rec.Body.String())
+	}
+	if rec.Header().Get("Cache-Control") == "public, max-age=0" {
+		t.Errorf("expected feed with downloaded media to be cached")
+	}
+}
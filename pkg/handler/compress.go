@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+	// minCompressSize is the smallest body worth compressing
+	minCompressSize = 512
+)
+
+// supportedEncodings lists the content codings in order of preference
+var supportedEncodings = []string{encodingBrotli, encodingGzip}
+
+// negotiateEncoding picks the preferred encoding accepted by the client according to
+// Accept-Encoding header. Empty string means that the body is sent uncompressed.
+func negotiateEncoding(acceptEncoding string, bodySize int) string {
+	if bodySize < minCompressSize {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		if quality, valid := acceptQuality(params); valid {
+			qualities[coding] = quality
+		}
+	}
+
+	encoding, bestQuality := "", 0.0
+	for _, candidate := range supportedEncodings {
+		quality, found := qualities[candidate]
+		if !found {
+			quality, found = qualities["*"]
+		}
+		if found && quality > bestQuality {
+			encoding, bestQuality = candidate, quality
+		}
+	}
+	return encoding
+}
+
+// encodeBody compresses body with the given encoding
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case encodingBrotli:
+		writer = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case encodingGzip:
+		writer = gzip.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, fmt.Errorf("error compressing body with %s: %w", encoding, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing body with %s: %w", encoding, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isCompressionCacheEnabled reports whether compressed responses are stored in the database
+func isCompressionCacheEnabled() bool {
+	enabledStr := os.Getenv("BHP_COMPRESSION_CACHE")
+	if enabledStr == "" {
+		return false
+	}
+
+	enabled, err := strconv.ParseBool(enabledStr)
+	if err != nil {
+		log.Printf("invalid BHP_COMPRESSION_CACHE %s, compression cache disabled", enabledStr)
+		return false
+	}
+	return enabled
+}
+
+// getEncodedBody returns body compressed with encoding. If the compression cache is
+// enabled, the compressed body is read from or stored to the database by its ETag.
+func getEncodedBody(db *sql.DB, entry cacheEntry, etag, encoding string, body []byte) ([]byte, error) {
+	if db == nil || !isCompressionCacheEnabled() {
+		return encodeBody(encoding, body)
+	}
+
+	var encoded []byte
+	err := db.QueryRow(
+		`SELECT body FROM encoded_responses WHERE etag = ? AND encoding = ?`,
+		etag, encoding,
+	).Scan(&encoded)
+	if err == nil {
+		return encoded, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("error querying encoded response: %w", err)
+	}
+
+	encoded, err = encodeBody(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storeEncodedBody(db, entry, etag, encoding, encoded)
+	if err != nil {
+		log.Printf("error storing encoded response of %s: %s", entry.key, err)
+	}
+	return encoded, nil
+}
+
+// storeEncodedBody stores the compressed body and removes responses of the previous
+// versions of the feed. Responses of each format and limit are cached separately.
+func storeEncodedBody(db *sql.DB, entry cacheEntry, etag, encoding string, encoded []byte) error {
+	fetchedAt := entry.fetchedAt.UnixNano()
+
+	_, err := db.Exec(
+		`DELETE FROM encoded_responses WHERE feed_id = ? AND fetched_at < ?`,
+		entry.key, fetchedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error removing outdated encoded responses: %w", err)
+	}
+
+	_, err = db.Exec(
+		`INSERT OR REPLACE INTO encoded_responses (etag, encoding, feed_id, fetched_at, body) VALUES (?, ?, ?, ?, ?)`,
+		etag, encoding, entry.key, fetchedAt, encoded,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting encoded response: %w", err)
+	}
+	return nil
+}
+
+// writeEncodedBody writes body compressed with encoding using encode. The body is sent
+// uncompressed if encoding is empty or compressing fails.
+func writeEncodedBody(w http.ResponseWriter, body []byte, encoding string, encode func(encoding string, body []byte) ([]byte, error)) {
+	if encoding != "" {
+		encoded, err := encode(encoding, body)
+		if err != nil {
+			log.Println("error compressing response:", err)
+			// the entity tag of the compressed representation no longer applies
+			w.Header().Set("ETag", computeETag(body, ""))
+		} else {
+			w.Header().Set("Content-Encoding", encoding)
+			body = encoded
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		log.Println("error writing response:", err)
+	}
+}
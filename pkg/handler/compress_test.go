@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/lattots/bhproxy/pkg/db"
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                            "",
+		"identity":                    "",
+		"gzip":                        "gzip",
+		"gzip, deflate, br":           "br",
+		"GZIP;q=1.0, br;q=0.5":        "gzip",
+		"br;q=0, gzip":                "gzip",
+		"*":                           "br",
+		"*;q=0.1, br;q=0":             "gzip",
+		"gzip;q=0, br;q=0":            "",
+		"gzip;q=invalid, deflate":     "",
+		"deflate, gzip;q=0.8, br;q=1": "br",
+		"br;level=1;q=0.1, gzip":      "gzip",
+	}
+
+	for acceptEncoding, expected := range tests {
+		if encoding := negotiateEncoding(acceptEncoding, minCompressSize); encoding != expected {
+			t.Errorf("%q: expected %q, got %q", acceptEncoding, expected, encoding)
+		}
+	}
+
+	if encoding := negotiateEncoding("gzip, br", minCompressSize-1); encoding != "" {
+		t.Errorf("expected small body not to be compressed, got %q", encoding)
+	}
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader
+	switch encoding {
+	case encodingBrotli:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case encodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gzipReader
+	default:
+		return string(body)
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("error decoding %s body: %s", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestWriteCompressedResponse(t *testing.T) {
+	database, err := db.OpenSqliteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if err := db.InitSqliteDB(database); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BHP_COMPRESSION_CACHE", "true")
+
+	f := &feed.Feed{ID: "1234", FetchedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	body := []byte(`{"caption":"` + strings.Repeat("long caption ", 100) + `"}`)
+
+	etags := map[string]bool{}
+	for _, encoding := range []string{"br", "gzip", "identity"} {
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/?id=1234", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rec := httptest.NewRecorder()
+			writeCachedResponse(rec, req, database, feedCacheEntry(f), body)
+
+			if encoding == "identity" {
+				encoding = ""
+			}
+			if rec.Header().Get("Content-Encoding") != encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", encoding, rec.Header().Get("Content-Encoding"))
+			}
+			if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept-Encoding") {
+				t.Errorf("%q: expected Vary: Accept-Encoding, got %v", encoding, rec.Header().Values("Vary"))
+			}
+			if decoded := decodeBody(t, encoding, rec.Body.Bytes()); decoded != string(body) {
+				t.Errorf("%q: decoded body differs from original", encoding)
+			}
+			etags[rec.Header().Get("ETag")] = true
+		}
+	}
+	if len(etags) != 3 {
+		t.Errorf("expected distinct entity tag for each encoding, got %v", etags)
+	}
+
+	var cachedCount int
+	database.QueryRow(`SELECT COUNT(*) FROM encoded_responses WHERE feed_id = ?`, "1234").Scan(&cachedCount)
+	if cachedCount != 2 {
+		t.Errorf("expected brotli and gzip responses to be cached, got %d", cachedCount)
+	}
+
+	// responses of the same feed version in other formats are cached side by side
+	req := httptest.NewRequest(http.MethodGet, "/?id=1234&format=rss", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	writeCachedResponse(httptest.NewRecorder(), req, database, feedCacheEntry(f), append(body, '\n'))
+	database.QueryRow(`SELECT COUNT(*) FROM encoded_responses WHERE feed_id = ?`, "1234").Scan(&cachedCount)
+	if cachedCount != 3 {
+		t.Errorf("expected responses of both formats to be cached, got %d", cachedCount)
+	}
+
+	// a new version of the feed replaces the cached responses
+	f.FetchedAt = f.FetchedAt.Add(time.Minute)
+	rec := httptest.NewRecorder()
+	writeCachedResponse(rec, req, database, feedCacheEntry(f), append(body, ' '))
+	if decodeBody(t, encodingGzip, rec.Body.Bytes()) != string(body)+" " {
+		t.Errorf("expected response of the new feed version")
+	}
+	database.QueryRow(`SELECT COUNT(*) FROM encoded_responses WHERE feed_id = ?`, "1234").Scan(&cachedCount)
+	if cachedCount != 1 {
+		t.Errorf("expected responses of the previous version to be removed, got %d", cachedCount)
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lattots/bhproxy/pkg/utility"
+)
+
+const corsMaxAge = "86400"
+
+// getAllowedOrigins returns the origins allowed to embed the feed. Origins are
+// separated by spaces, "*" allows any origin.
+func getAllowedOrigins(feedID string) []string {
+	return strings.Fields(utility.GetFeedSetting("BHP_CORS_ALLOWED_ORIGINS", feedID))
+}
+
+// setCORSHeaders allows the requesting origin to read the response if the origin
+// is allowed for all of the feeds. It reports whether the origin was allowed.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, feedIDs ...string) bool {
+	// the response depends on the origin even when the origin is not allowed
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(feedIDs) == 0 {
+		return false
+	}
+
+	anyOrigin := true
+	for _, feedID := range feedIDs {
+		allowedOrigins := getAllowedOrigins(feedID)
+		if slices.Contains(allowedOrigins, "*") {
+			continue
+		}
+		anyOrigin = false
+		if !slices.Contains(allowedOrigins, strings.TrimSuffix(origin, "/")) {
+			return false
+		}
+	}
+
+	if anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Bhproxy-Stale, X-Bhproxy-Partial")
+	return true
+}
+
+// requestFeedIDs returns the feed IDs of query parameter id or the comma-separated
+// IDs of a timeline given with parameter ids
+func requestFeedIDs(r *http.Request) []string {
+	query := r.URL.Query()
+	if ids := query.Get("ids"); ids != "" {
+		return parseFeedIDs(ids)
+	}
+	return []string{query.Get("id")}
+}
+
+// HandlePreflight answers CORS preflight requests of the feed endpoints
+func (h *sqliteHandler) HandlePreflight(w http.ResponseWriter, r *http.Request) {
+	if setCORSHeaders(w, r, requestFeedIDs(r)...) {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "If-None-Match, If-Modified-Since")
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	}
+	w.Header().Set("Allow", "GET, OPTIONS")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSetCORSHeaders(t *testing.T) {
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS", "https://example.com https://www.example.com")
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS_PER_FEED", "open=*,customer=https://customer.fi")
+
+	tests := []struct {
+		feedID   string
+		origin   string
+		expected string
+	}{
+		{"1234", "https://example.com", "https://example.com"},
+		{"1234", "https://www.example.com", "https://www.example.com"},
+		{"1234", "https://customer.fi", ""},
+		{"1234", "", ""},
+		{"customer", "https://customer.fi", "https://customer.fi"},
+		{"customer", "https://example.com", ""},
+		{"open", "https://anywhere.com", "*"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/?id="+test.feedID, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rec := httptest.NewRecorder()
+
+		allowed := setCORSHeaders(rec, req, test.feedID)
+		if allowed != (test.expected != "") {
+			t.Errorf("%s from %q: expected allowed to be %t", test.feedID, test.origin, test.expected != "")
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != test.expected {
+			t.Errorf("%s from %q: expected Access-Control-Allow-Origin %q, got %q", test.feedID, test.origin, test.expected, got)
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s from %q: expected Vary: Origin", test.feedID, test.origin)
+		}
+	}
+}
+
+func TestHandlePreflight(t *testing.T) {
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS", "https://example.com")
+	h := &sqliteHandler{}
+
+	req := httptest.NewRequest(http.MethodOptions, "/?id=1234", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	h.HandlePreflight(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("expected origin to be allowed, got headers %v", rec.Header())
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") != "GET, OPTIONS" {
+		t.Errorf("expected allowed methods, got headers %v", rec.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	rec = httptest.NewRecorder()
+	h.HandlePreflight(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("expected no CORS headers for disallowed origin, got %v", rec.Header())
+	}
+}
+
+func TestSetCORSHeadersForTimeline(t *testing.T) {
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS", "https://example.com")
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS_PER_FEED", "open=*,open2=*,customer=https://customer.fi")
+
+	tests := []struct {
+		feedIDs  []string
+		expected string
+	}{
+		{[]string{"1234", "5678"}, "https://example.com"},
+		{[]string{"1234", "open"}, "https://example.com"},
+		{[]string{"1234", "customer"}, ""},
+		{[]string{"open", "open2"}, "*"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/timeline", nil)
+		req.Header.Set("Origin", "https://example.com")
+		rec := httptest.NewRecorder()
+
+		setCORSHeaders(rec, req, test.feedIDs...)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != test.expected {
+			t.Errorf("%v: expected Access-Control-Allow-Origin %q, got %q", test.feedIDs, test.expected, got)
+		}
+	}
+}
+
+func TestRequestFeedIDs(t *testing.T) {
+	tests := map[string][]string{
+		"/?id=1234":                      {"1234"},
+		"/timeline?ids=open,%20open2":    {"open", "open2"},
+		"/timeline?ids=open2,open,open2": {"open2", "open"},
+	}
+	for url, expected := range tests {
+		if ids := requestFeedIDs(httptest.NewRequest(http.MethodOptions, url, nil)); !slices.Equal(ids, expected) {
+			t.Errorf("%s: expected %v, got %v", url, expected, ids)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+// errorResponse is the JSON body of failed requests
+type errorResponse struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	FeedID string `json:"feedId"`
+}
+
+const (
+	codeInvalidFeedID       = "invalid_feed_id"
+	codeInvalidLimit        = "invalid_limit"
+	codeInvalidFormat       = "invalid_format"
+	codeInvalidMedia        = "invalid_media"
+	codeInvalidWidgetOption = "invalid_widget_option"
+	codeInvalidFilter       = "invalid_filter"
+	codeTooManyFeeds        = "too_many_feeds"
+	codeFeedNotAllowed      = "feed_not_allowed"
+	codeFeedNotFound        = "feed_not_found"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeInternalError       = "internal_error"
+)
+
+// writeError writes a JSON error response with the given status
+func writeError(w http.ResponseWriter, status int, code, message, feedID string) {
+	w.Header().Set("Content-Type", "application/json")
+	// errors must not be cached like feeds
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(errorResponse{Error: message, Code: code, FeedID: feedID})
+	if err != nil {
+		log.Println("error encoding error response:", err)
+	}
+}
+
+// writeFeedError maps errors of package feed to status codes and writes them as
+// a JSON error response. Unknown errors are logged and reported as internal errors.
+func writeFeedError(w http.ResponseWriter, err error, feedID string) {
+	switch {
+	case errors.Is(err, feed.ErrInvalidFeedID):
+		writeError(w, http.StatusBadRequest, codeInvalidFeedID, "Feed ID is invalid.", feedID)
+	case errors.Is(err, feed.ErrFeedNotAllowed):
+		writeError(w, http.StatusForbidden, codeFeedNotAllowed, "Feed is not served by this proxy.", feedID)
+	case errors.Is(err, feed.ErrFeedNotExists):
+		writeError(w, http.StatusNotFound, codeFeedNotFound, "Feed does not exist.", feedID)
+	case errors.Is(err, feed.ErrCircuitOpen):
+		// Behold is not contacted until the breaker cools down
+		writeError(w, http.StatusServiceUnavailable, codeUpstreamUnavailable, "Feed can't be fetched from Behold at the moment.", feedID)
+	case errors.Is(err, feed.ErrUpstreamUnavailable):
+		writeError(w, http.StatusBadGateway, codeUpstreamUnavailable, "Feed could not be fetched from Behold.", feedID)
+	default:
+		writeError(w, http.StatusInternalServerError, codeInternalError, "Feed could not be served.", feedID)
+	}
+}
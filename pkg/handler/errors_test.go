@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func TestWriteFeedError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{feed.ErrInvalidFeedID, http.StatusBadRequest, codeInvalidFeedID},
+		{fmt.Errorf("not whitelisted: %w", feed.ErrFeedNotAllowed), http.StatusForbidden, codeFeedNotAllowed},
+		{fmt.Errorf("error fetching feed: %w", feed.ErrFeedNotExists), http.StatusNotFound, codeFeedNotFound},
+		{fmt.Errorf("%w: timeout", feed.ErrUpstreamUnavailable), http.StatusBadGateway, codeUpstreamUnavailable},
+		{fmt.Errorf("%w: %w", feed.ErrUpstreamUnavailable, feed.ErrCircuitOpen), http.StatusServiceUnavailable, codeUpstreamUnavailable},
+		{feed.ErrCircuitOpen, http.StatusServiceUnavailable, codeUpstreamUnavailable},
+		{errors.New("disk full"), http.StatusInternalServerError, codeInternalError},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		writeFeedError(rec, test.err, "1234")
+
+		if rec.Code != test.status {
+			t.Errorf("%v: expected status %d, got %d", test.err, test.status, rec.Code)
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%v: expected JSON content type, got %s", test.err, rec.Header().Get("Content-Type"))
+		}
+
+		var body errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("%v: error decoding body: %s", test.err, err)
+		}
+		if body.Code != test.code || body.FeedID != "1234" || body.Error == "" {
+			t.Errorf("%v: unexpected body %+v", test.err, body)
+		}
+	}
+}
+
+func TestHandleGetFeedInvalidID(t *testing.T) {
+	h := &sqliteHandler{}
+
+	for _, id := range []string{"", "../1234", "..%2F..%2Fetc%2Fpasswd"} {
+		rec := httptest.NewRecorder()
+		h.HandleGetFeed(rec, httptest.NewRequest(http.MethodGet, "/?id="+id, nil))
+
+		var body errorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusBadRequest || body.Code != codeInvalidFeedID {
+			t.Errorf("%q: expected 400 %s, got %d %+v", id, codeInvalidFeedID, rec.Code, body)
+		}
+	}
+}
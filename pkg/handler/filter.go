@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+// maxKeywordLength is the maximum length of query parameter q in characters
+const maxKeywordLength = 100
+
+// validHashtag matches a hashtag with or without leading #
+var validHashtag = regexp.MustCompile(`^#?[\p{L}\p{N}_]{1,100}$`)
+
+// filterMediaTypes maps values of query parameter type to Behold media types
+var filterMediaTypes = map[string]string{
+	"image":          feed.MediaTypeImage,
+	"video":          feed.MediaTypeVideo,
+	"carousel_album": feed.MediaTypeCarouselAlbum,
+}
+
+// parsePostFilter returns the post filter given as optional query parameters type, tag,
+// q, since and until. The error message can be shown to the client.
+func parsePostFilter(r *http.Request) (feed.PostFilter, error) {
+	query := r.URL.Query()
+	var filter feed.PostFilter
+
+	if typesStr := query.Get("type"); typesStr != "" {
+		for _, typeStr := range strings.Split(typesStr, ",") {
+			mediaType, ok := filterMediaTypes[strings.ToLower(strings.TrimSpace(typeStr))]
+			if !ok {
+				return filter, errors.New("Type must be image, video or carousel_album.")
+			}
+			filter.MediaTypes = append(filter.MediaTypes, mediaType)
+		}
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		if !validHashtag.MatchString(tag) {
+			return filter, errors.New("Tag must be a hashtag of letters, numbers and underscores.")
+		}
+		filter.Hashtag = strings.TrimPrefix(tag, "#")
+	}
+
+	filter.Keyword = strings.TrimSpace(query.Get("q"))
+	if utf8.RuneCountInString(filter.Keyword) > maxKeywordLength {
+		return filter, fmt.Errorf("Keyword can have at most %d characters.", maxKeywordLength)
+	}
+
+	var err error
+	filter.Since, err = parseFilterTime(query.Get("since"), false)
+	if err != nil {
+		return filter, errors.New("Since must be a date or RFC 3339 timestamp.")
+	}
+	filter.Until, err = parseFilterTime(query.Get("until"), true)
+	if err != nil {
+		return filter, errors.New("Until must be a date or RFC 3339 timestamp.")
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return filter, errors.New("Until must not be before since.")
+	}
+	return filter, nil
+}
+
+// parseFilterTime parses an RFC 3339 timestamp or a date in UTC, e.g. 2025-06-30.
+// Date means the end of the day if endOfDay is set. Empty value returns zero time.
+func parseFilterTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
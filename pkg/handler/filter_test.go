@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func TestParsePostFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?id=1234&type=image,VIDEO&tag=%23kes%C3%A4&q=+beach+&since=2025-06-01&until=2025-06-30", nil)
+	filter, err := parsePostFilter(req)
+	if err != nil {
+		t.Fatalf("parsePostFilter returned an error: %s", err)
+	}
+	if !slices.Equal(filter.MediaTypes, []string{feed.MediaTypeImage, feed.MediaTypeVideo}) {
+		t.Errorf("unexpected media types %v", filter.MediaTypes)
+	}
+	if filter.Hashtag != "kesä" || filter.Keyword != "beach" {
+		t.Errorf("unexpected hashtag %q and keyword %q", filter.Hashtag, filter.Keyword)
+	}
+	if !filter.Since.Equal(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected since %s", filter.Since)
+	}
+	if !filter.Until.Equal(time.Date(2025, 6, 30, 23, 59, 59, 999999999, time.UTC)) {
+		t.Errorf("expected until to be the end of the day, got %s", filter.Until)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/?id=1234&until=2025-06-30T12:00:00%2B03:00", nil)
+	filter, err = parsePostFilter(req)
+	if err != nil {
+		t.Fatalf("parsePostFilter returned an error: %s", err)
+	}
+	if !filter.Until.Equal(time.Date(2025, 6, 30, 9, 0, 0, 0, time.UTC)) || !filter.Since.IsZero() {
+		t.Errorf("unexpected time range %s - %s", filter.Since, filter.Until)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/?id=1234", nil)
+	if filter, err = parsePostFilter(req); err != nil || !filter.IsEmpty() {
+		t.Errorf("expected empty filter without parameters, got %+v and %v", filter, err)
+	}
+
+	invalidQueries := []string{
+		"type=reel",
+		"type=image,",
+		"tag=%23",
+		"tag=summer*",
+		"tag=two+words",
+		"q=" + strings.Repeat("a", 101),
+		"since=yesterday",
+		"until=2025-13-01",
+		"since=2025-06-30&until=2025-06-01",
+	}
+	for _, query := range invalidQueries {
+		req := httptest.NewRequest(http.MethodGet, "/?id=1234&"+query, nil)
+		if _, err := parsePostFilter(req); err == nil {
+			t.Errorf("expected %s to be invalid", query)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+// output formats of the feed endpoint
+const (
+	formatJSON     = "json"
+	formatRSS      = "rss"
+	formatAtom     = "atom"
+	formatJSONFeed = "jsonfeed"
+)
+
+// formatContentTypes maps output formats to their content types
+var formatContentTypes = map[string]string{
+	formatJSON:     "application/json",
+	formatRSS:      "application/rss+xml; charset=utf-8",
+	formatAtom:     "application/atom+xml; charset=utf-8",
+	formatJSONFeed: "application/feed+json",
+}
+
+// acceptMediaTypes maps media types of Accept header to output formats
+var acceptMediaTypes = map[string]string{
+	"application/json":      formatJSON,
+	"application/rss+xml":   formatRSS,
+	"application/atom+xml":  formatAtom,
+	"application/feed+json": formatJSONFeed,
+}
+
+// maxTitleLength is the length of item titles derived from captions
+const maxTitleLength = 100
+
+// negotiateFormat returns the output format given by query parameter format or
+// Accept header. It reports false if the requested format is unknown.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, found := formatContentTypes[format]
+		return format, found
+	}
+
+	// the format with the highest quality wins, the first listed of equal ones
+	format, bestQuality := formatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		candidate, found := acceptMediaTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+		if !found {
+			continue
+		}
+		if quality, valid := acceptQuality(params); valid && quality > bestQuality {
+			format, bestQuality = candidate, quality
+		}
+	}
+
+	return format, true
+}
+
+// acceptQuality returns the q parameter of an Accept or Accept-Encoding header element,
+// 1 by default. It reports false if the quality is invalid.
+func acceptQuality(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || quality < 0 || quality > 1 {
+			return 0, false
+		}
+		return quality, true
+	}
+	return 1, true
+}
+
+// renderFeed encodes the feed in the given format. Relative URLs of stored images are
+// resolved against the request URL because feed readers need absolute URLs.
+func renderFeed(r *http.Request, f *feed.Feed, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case formatJSON:
+		err = json.NewEncoder(&buf).Encode(f)
+	case formatRSS:
+		err = encodeXML(&buf, newRSSFeed(r, f))
+	case formatAtom:
+		err = encodeXML(&buf, newAtomFeed(r, f))
+	case formatJSONFeed:
+		err = json.NewEncoder(&buf).Encode(newJSONFeed(r, f))
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding feed as %s: %w", format, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeXML(buf *bytes.Buffer, v any) error {
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	return nil
+}
+
+// absoluteURL resolves ref against the URL of the request
+func absoluteURL(r *http.Request, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	base := &url.URL{Scheme: requestScheme(r), Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+
+	return base.ResolveReference(refURL).String()
+}
+
+// requestScheme returns the scheme the client used. CGI and FastCGI requests have TLS state
+// when the web server passes HTTPS=on. Behind a TLS terminating reverse proxy header
+// X-Forwarded-Proto is used if BHP_TRUST_FORWARDED_PROTO is set.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if isForwardedProtoTrusted() {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		proto = strings.ToLower(strings.TrimSpace(proto))
+		if proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// isForwardedProtoTrusted reports whether the reverse proxy sets X-Forwarded-Proto. Otherwise
+// clients could choose the scheme of the returned URLs.
+func isForwardedProtoTrusted() bool {
+	trustedStr := os.Getenv("BHP_TRUST_FORWARDED_PROTO")
+	if trustedStr == "" {
+		return false
+	}
+
+	trusted, err := strconv.ParseBool(trustedStr)
+	if err != nil {
+		log.Printf("invalid BHP_TRUST_FORWARDED_PROTO %s, X-Forwarded-Proto ignored", trustedStr)
+		return false
+	}
+	return trusted
+}
+
+// profileURL returns the Instagram profile of the feed
+func profileURL(f *feed.Feed) string {
+	return "https://www.instagram.com/" + url.PathEscape(f.Username) + "/"
+}
+
+// postTitle returns the first line of the caption shortened to maxTitleLength
+func postTitle(post *feed.Post) string {
+	caption := post.PrunedCaption
+	if caption == "" {
+		caption = post.Caption
+	}
+	title, _, _ := strings.Cut(strings.TrimSpace(caption), "\n")
+
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength-1]) + "…"
+	}
+	if title == "" {
+		title = "Post on " + post.Timestamp.Format("2 January 2006")
+	}
+	return title
+}
+
+// imageType returns the content type of the image by its extension
+func imageType(imageURL string) string {
+	if contentType := mime.TypeByExtension(path.Ext(imageURL)); contentType != "" {
+		return contentType
+	}
+	return "image/webp"
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Image         *rssImage `xml:"image,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL string `xml:"url,attr"`
+	// Length is required by RSS but the size of stored images is not known
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// newRSSFeed converts the feed to RSS 2.0
+func newRSSFeed(r *http.Request, f *feed.Feed) rssFeed {
+	channel := rssChannel{
+		Title:         f.Username,
+		Link:          profileURL(f),
+		Description:   f.Biography,
+		AtomLink:      atomLink{Href: absoluteURL(r, r.URL.String()), Rel: "self", Type: "application/rss+xml"},
+		LastBuildDate: f.FetchedAt.UTC().Format(time.RFC1123Z),
+		Items:         []rssItem{},
+	}
+	if f.ProfilePictureUrl != "" {
+		channel.Image = &rssImage{URL: f.ProfilePictureUrl, Title: f.Username, Link: profileURL(f)}
+	}
+
+	for i := range f.Posts {
+		post := &f.Posts[i]
+		item := rssItem{
+			Title:       postTitle(post),
+			Link:        post.Permalink,
+			Description: post.Caption,
+			GUID:        rssGUID{IsPermaLink: true, Value: post.Permalink},
+			PubDate:     post.Timestamp.UTC().Format(time.RFC1123Z),
+		}
+		if post.MediaSmallUrl != "" {
+			item.Enclosure = &rssEnclosure{URL: absoluteURL(r, post.MediaSmallUrl), Type: imageType(post.MediaSmallUrl)}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	return rssFeed{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", Channel: channel}
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomAuthor  `xml:"author"`
+	Icon     string      `xml:"icon,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Links     []atomLink  `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// newAtomFeed converts the feed to Atom
+func newAtomFeed(r *http.Request, f *feed.Feed) atomFeed {
+	atom := atomFeed{
+		ID:       profileURL(f),
+		Title:    f.Username,
+		Subtitle: f.Biography,
+		Updated:  f.FetchedAt.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: absoluteURL(r, r.URL.String()), Rel: "self", Type: "application/atom+xml"},
+			{Href: profileURL(f), Rel: "alternate", Type: "text/html"},
+		},
+		Author:  atomAuthor{Name: f.Username, URI: profileURL(f)},
+		Icon:    f.ProfilePictureUrl,
+		Entries: []atomEntry{},
+	}
+
+	for i := range f.Posts {
+		post := &f.Posts[i]
+		entry := atomEntry{
+			ID:        post.Permalink,
+			Title:     postTitle(post),
+			Updated:   post.Timestamp.UTC().Format(time.RFC3339),
+			Published: post.Timestamp.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Href: post.Permalink, Rel: "alternate", Type: "text/html"}},
+			Content:   atomContent{Type: "text", Value: post.Caption},
+		}
+		if post.MediaSmallUrl != "" {
+			entry.Links = append(entry.Links, atomLink{
+				Href: absoluteURL(r, post.MediaSmallUrl), Rel: "enclosure", Type: imageType(post.MediaSmallUrl),
+			})
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+
+	return atom
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentText   string               `json:"content_text"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+// newJSONFeed converts the feed to JSON Feed 1.1
+func newJSONFeed(r *http.Request, f *feed.Feed) jsonFeed {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Username,
+		HomePageURL: profileURL(f),
+		FeedURL:     absoluteURL(r, r.URL.String()),
+		Description: f.Biography,
+		Icon:        f.ProfilePictureUrl,
+		Authors:     []jsonFeedAuthor{{Name: f.Username, URL: profileURL(f), Avatar: f.ProfilePictureUrl}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for i := range f.Posts {
+		post := &f.Posts[i]
+		item := jsonFeedItem{
+			ID:            post.ID,
+			URL:           post.Permalink,
+			Title:         postTitle(post),
+			ContentText:   post.Caption,
+			DatePublished: post.Timestamp.UTC().Format(time.RFC3339),
+		}
+		if post.MediaSmallUrl != "" {
+			item.Image = absoluteURL(r, post.MediaSmallUrl)
+			item.Attachments = []jsonFeedAttachment{{URL: item.Image, MimeType: imageType(post.MediaSmallUrl)}}
+		}
+		jf.Items = append(jf.Items, item)
+	}
+
+	return jf
+}
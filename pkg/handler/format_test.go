@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		url      string
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"/?id=1234", "", formatJSON, true},
+		{"/?id=1234", "*/*", formatJSON, true},
+		{"/?id=1234&format=rss", "", formatRSS, true},
+		{"/?id=1234&format=atom", "application/rss+xml", formatAtom, true},
+		{"/?id=1234&format=jsonfeed", "", formatJSONFeed, true},
+		{"/?id=1234", "application/atom+xml, application/xml;q=0.9", formatAtom, true},
+		{"/?id=1234", "text/html, application/feed+json;q=0.8", formatJSONFeed, true},
+		{"/?id=1234", "application/rss+xml;q=0", formatJSON, true},
+		{"/?id=1234", "application/rss+xml;q=0.1, application/atom+xml", formatAtom, true},
+		{"/?id=1234", "application/rss+xml; charset=utf-8; q=0.5, application/json;q=0.9", formatJSON, true},
+		{"/?id=1234", "application/atom+xml;q=0.5, application/rss+xml;q=0.5", formatAtom, true},
+		{"/?id=1234", "application/rss+xml;q=high, application/feed+json;q=0.2", formatJSONFeed, true},
+		{"/?id=1234&format=xml", "", "xml", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		req.Header.Set("Accept", test.accept)
+		format, ok := negotiateFormat(req)
+		if format != test.expected || ok != test.ok {
+			t.Errorf("%s with Accept %q: expected %s %t, got %s %t", test.url, test.accept, test.expected, test.ok, format, ok)
+		}
+	}
+}
+
+func newTestFeed() *feed.Feed {
+	return &feed.Feed{
+		ID:                "1234",
+		Username:          "johndoe",
+		Biography:         "Photos & <stuff>",
+		ProfilePictureUrl: "https://example.com/johndoe.jpg",
+		FetchedAt:         time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC),
+		Posts: []feed.Post{
+			{
+				ID:            "post1",
+				Permalink:     "https://www.instagram.com/p/abcd/",
+				Timestamp:     time.Date(2025, 1, 29, 18, 34, 9, 0, time.UTC),
+				MediaSmallUrl: "/images/post1.webp",
+				Caption:       "Sunny day at the beach\nMore text #summer",
+				PrunedCaption: "Sunny day at the beach\nMore text",
+			},
+			{
+				ID:        "post2",
+				Permalink: "https://www.instagram.com/p/efgh/",
+				Timestamp: time.Date(2025, 1, 28, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+}
+
+func TestRenderRSS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://proxy.example.com/feed?id=1234&format=rss", nil)
+	body, err := renderFeed(req, newTestFeed(), formatRSS)
+	if err != nil {
+		t.Fatalf("renderFeed returned an error: %s", err)
+	}
+
+	var rss rssFeed
+	if err := xml.Unmarshal(body, &rss); err != nil {
+		t.Fatalf("invalid RSS: %s\n%s", err, body)
+	}
+	if rss.Version != "2.0" || rss.Channel.Title != "johndoe" || rss.Channel.Description != "Photos & <stuff>" {
+		t.Errorf("unexpected channel %+v", rss.Channel)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(rss.Channel.Items))
+	}
+
+	item := rss.Channel.Items[0]
+	if item.Title != "Sunny day at the beach" || item.Link != "https://www.instagram.com/p/abcd/" || item.PubDate != "Wed, 29 Jan 2025 18:34:09 +0000" {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if item.Enclosure == nil || item.Enclosure.URL != "https://proxy.example.com/images/post1.webp" || item.Enclosure.Type != "image/webp" {
+		t.Errorf("unexpected enclosure %+v", item.Enclosure)
+	}
+	if rss.Channel.Items[1].Enclosure != nil || rss.Channel.Items[1].Title != "Post on 28 January 2025" {
+		t.Errorf("unexpected item without media %+v", rss.Channel.Items[1])
+	}
+}
+
+func TestRenderAtom(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/?id=1234&format=atom", nil)
+	body, err := renderFeed(req, newTestFeed(), formatAtom)
+	if err != nil {
+		t.Fatalf("renderFeed returned an error: %s", err)
+	}
+	if !strings.Contains(string(body), `<feed xmlns="http://www.w3.org/2005/Atom">`) {
+		t.Errorf("expected Atom namespace, got %s", body)
+	}
+
+	var atom atomFeed
+	if err := xml.Unmarshal(body, &atom); err != nil {
+		t.Fatalf("invalid Atom: %s\n%s", err, body)
+	}
+	if atom.ID != "https://www.instagram.com/johndoe/" || atom.Updated != "2025-02-01T12:00:00Z" || atom.Author.Name != "johndoe" {
+		t.Errorf("unexpected feed %+v", atom)
+	}
+	if atom.Links[0].Href != "http://proxy.example.com/?id=1234&format=atom" || atom.Links[0].Rel != "self" {
+		t.Errorf("unexpected self link %+v", atom.Links[0])
+	}
+
+	entry := atom.Entries[0]
+	if entry.ID != "https://www.instagram.com/p/abcd/" || entry.Content.Value != "Sunny day at the beach\nMore text #summer" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if len(entry.Links) != 2 || entry.Links[1].Rel != "enclosure" || entry.Links[1].Href != "http://proxy.example.com/images/post1.webp" {
+		t.Errorf("unexpected entry links %+v", entry.Links)
+	}
+}
+
+func TestRenderJSONFeed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/?id=1234&format=jsonfeed", nil)
+	body, err := renderFeed(req, newTestFeed(), formatJSONFeed)
+	if err != nil {
+		t.Fatalf("renderFeed returned an error: %s", err)
+	}
+
+	var jf jsonFeed
+	if err := json.Unmarshal(body, &jf); err != nil {
+		t.Fatalf("invalid JSON Feed: %s", err)
+	}
+	if jf.Version != "https://jsonfeed.org/version/1.1" || jf.FeedURL != "http://proxy.example.com/?id=1234&format=jsonfeed" {
+		t.Errorf("unexpected feed %+v", jf)
+	}
+	if len(jf.Items) != 2 || jf.Items[0].Image != "http://proxy.example.com/images/post1.webp" || jf.Items[0].DatePublished != "2025-01-29T18:34:09Z" {
+		t.Errorf("unexpected items %+v", jf.Items)
+	}
+	if len(jf.Items[0].Attachments) != 1 || jf.Items[0].Attachments[0].MimeType != "image/webp" {
+		t.Errorf("unexpected attachments %+v", jf.Items[0].Attachments)
+	}
+}
+
+func TestPostTitle(t *testing.T) {
+	post := &feed.Post{Caption: strings.Repeat("ä", 150)}
+	title := postTitle(post)
+	if len([]rune(title)) != maxTitleLength || !strings.HasSuffix(title, "…") {
+		t.Errorf("expected title shortened to %d characters, got %q", maxTitleLength, title)
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/cgi-bin/bhproxy.cgi/widget?id=1234", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if url := absoluteURL(req, "/images/post1.webp"); url != "http://proxy.example.com/images/post1.webp" {
+		t.Errorf("expected X-Forwarded-Proto to be ignored by default, got %s", url)
+	}
+
+	t.Setenv("BHP_TRUST_FORWARDED_PROTO", "true")
+	if url := absoluteURL(req, "/images/post1.webp"); url != "https://proxy.example.com/images/post1.webp" {
+		t.Errorf("expected scheme of X-Forwarded-Proto, got %s", url)
+	}
+	if url := absoluteURL(req, "https://cdn.example.org/post1.webp"); url != "https://cdn.example.org/post1.webp" {
+		t.Errorf("expected absolute URL to be kept, got %s", url)
+	}
+
+	req.Header.Set("X-Forwarded-Proto", "javascript")
+	if url := absoluteURL(req, "?id=1234"); url != "http://proxy.example.com/cgi-bin/bhproxy.cgi/widget?id=1234" {
+		t.Errorf("expected unknown scheme to be ignored, got %s", url)
+	}
+}
@@ -2,11 +2,11 @@ package handler
 
 import (
 	"database/sql"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/lattots/bhproxy/pkg/db"
 	"github.com/lattots/bhproxy/pkg/feed"
@@ -14,44 +14,159 @@ import (
 
 type Handler interface {
 	HandleGetFeed(http.ResponseWriter, *http.Request)
+	HandlePreflight(http.ResponseWriter, *http.Request)
+	HandleGetWidget(http.ResponseWriter, *http.Request)
+	HandleGetWidgetScript(http.ResponseWriter, *http.Request)
+	HandleGetTimeline(http.ResponseWriter, *http.Request)
 }
 
 type sqliteHandler struct {
-	db *sql.DB
+	db     *sql.DB
+	client *feed.Client
 }
 
 func (h *sqliteHandler) HandleGetFeed(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
-	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	setCORSHeaders(w, r, id)
+	if !feed.IsValidFeedID(id) {
+		writeFeedError(w, feed.ErrInvalidFeedID, id)
 		return
 	}
 
-	log.Printf("HandleGetFeed for %s", id)
+	limit, ok := parseLimit(w, r, id)
+	if !ok {
+		return
+	}
+
+	format, ok := negotiateFormat(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidFormat, "Format must be json, rss, atom or jsonfeed.", id)
+		return
+	}
 
-	f, err := feed.GetFeedWithID(h.db, id)
-	if errors.Is(err, feed.ErrFeedNotExists) {
-		w.WriteHeader(http.StatusNotFound)
-		log.Println("feed doesn't exist")
+	media, ok := parseMediaSelection(r, format)
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidMedia, "Media must be all or small.", id)
 		return
 	}
+
+	filter, err := parsePostFilter(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("error getting feed:", err)
+		writeError(w, http.StatusBadRequest, codeInvalidFilter, err.Error(), id)
 		return
 	}
+
+	log.Printf("HandleGetFeed for %s", id)
+
+	f := h.getFeed(w, id, limit, filter, media)
 	if f == nil {
-		w.WriteHeader(http.StatusNotFound)
-		log.Println("feed not found with id:", id)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(f); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	body, err := renderFeed(r, f, format)
+	if err != nil {
 		log.Println("error encoding feed to response:", err)
+		writeFeedError(w, err, id)
 		return
 	}
+
+	if f.IsStale() {
+		w.Header().Set("X-Bhproxy-Stale", "true")
+	}
+	// without format parameter the format depends on Accept header
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	writeCachedResponse(w, r, h.db, feedCacheEntry(f), body)
+
+	h.pruneAfterResponse(w, f)
+}
+
+// parseLimit returns the optional query parameter limit. Zero returns all posts
+// configured for the feed. Invalid limit is reported to the client.
+func parseLimit(w http.ResponseWriter, r *http.Request, id string) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return 0, true
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, codeInvalidLimit, "Limit must be a positive integer.", id)
+		return 0, false
+	}
+	return limit, true
+}
+
+// parseMediaSelection returns the media files the response uses. JSON includes all media
+// unless query parameter media is small, e.g. for the JavaScript widget. Syndication
+// formats include only the small image of each post.
+func parseMediaSelection(r *http.Request, format string) (feed.MediaSelection, bool) {
+	switch r.URL.Query().Get("media") {
+	case "", "all":
+		if format == formatJSON {
+			return feed.AllMedia, true
+		}
+		return feed.SmallImages, true
+	case "small":
+		return feed.SmallImages, true
+	default:
+		return feed.AllMedia, false
+	}
+}
+
+// getFeed returns the feed with posts selected by filter and the media files the response
+// uses. It writes an error response and returns nil if the feed can't be served.
+func (h *sqliteHandler) getFeed(w http.ResponseWriter, id string, limit int, filter feed.PostFilter, media feed.MediaSelection) *feed.Feed {
+	f, err := feed.GetFilteredFeed(h.db, h.client, id, limit, filter, media)
+	if err != nil {
+		log.Printf("error getting feed %s: %s", id, err)
+		writeFeedError(w, err, id)
+		return nil
+	}
+	if f == nil {
+		log.Println("feed not found with id:", id)
+		writeFeedError(w, feed.ErrFeedNotExists, id)
+		return nil
+	}
+	return f
+}
+
+// pruneAfterResponse removes deprecated posts of the feed. It is called only after
+// the client has its response. Posts become deprecated only when the feed is refreshed
+// from Behold, so feeds served from the database are not pruned. This keeps CGI
+// responses, which complete only when the process exits, fast between refreshes.
+func (h *sqliteHandler) pruneAfterResponse(w http.ResponseWriter, f *feed.Feed) {
+	if !f.IsRefreshed() || !isPruneAfterResponseEnabled() {
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	report, err := feed.PruneFeed(h.db, f.ID)
+	if err != nil {
+		log.Printf("error pruning feed %s: %s", f.ID, err)
+		return
+	}
+	if len(report.PostIDs) > 0 {
+		log.Printf("pruned %d posts, %d children and %d files from feed %s",
+			len(report.PostIDs), len(report.ChildIDs), len(report.RemovedFiles), f.ID)
+	}
+}
+
+// isPruneAfterResponseEnabled reports whether feeds are pruned after responses. It can be
+// disabled when pruning runs as a maintenance job instead.
+func isPruneAfterResponseEnabled() bool {
+	enabledStr := os.Getenv("BHP_PRUNE_AFTER_RESPONSE")
+	if enabledStr == "" {
+		return true
+	}
+
+	enabled, err := strconv.ParseBool(enabledStr)
+	if err != nil {
+		log.Printf("invalid BHP_PRUNE_AFTER_RESPONSE %s, pruning after response enabled", enabledStr)
+		return true
+	}
+	return enabled
 }
 
 func NewSqliteHandler(filename string) (Handler, error) {
@@ -63,5 +178,9 @@ func NewSqliteHandler(filename string) (Handler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error initializing sqlite db: %w", err)
 	}
-	return &sqliteHandler{db: database}, nil
+	client, err := feed.NewClientFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Behold client: %w", err)
+	}
+	return &sqliteHandler{db: database, client: client}, nil
 }
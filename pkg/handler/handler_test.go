@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -25,6 +26,8 @@ func TestNewSqliteHandler(t *testing.T) {
 
 func TestSqliteHandler(t *testing.T) {
 	testDBfilepath := "data/db.sqlite"
+	// test posts have images hosted on gstatic.com
+	t.Setenv("BHP_MEDIA_ALLOWED_HOSTS", "gstatic.com")
 
 	handler, err := NewSqliteHandler(testDBfilepath)
 	if err != nil {
@@ -137,3 +140,44 @@ func populateTestDB(filepath string) error {
 	_, err = testDB.Exec(upsertPostsQuery)
 	return err
 }
+
+func TestIsPruneAfterResponseEnabled(t *testing.T) {
+	tests := map[string]bool{
+		"":          true,
+		"true":      true,
+		"false":     false,
+		"sometimes": true,
+	}
+
+	// CGI mode is pruned as well, as pruning runs only after the feed is refreshed
+	t.Setenv("GATEWAY_INTERFACE", "CGI/1.1")
+	for setting, expected := range tests {
+		t.Setenv("BHP_PRUNE_AFTER_RESPONSE", setting)
+		if enabled := isPruneAfterResponseEnabled(); enabled != expected {
+			t.Errorf("BHP_PRUNE_AFTER_RESPONSE %q: expected %t, got %t", setting, expected, enabled)
+		}
+	}
+}
+
+func TestParseMediaSelection(t *testing.T) {
+	tests := []struct {
+		query    string
+		format   string
+		expected feed.MediaSelection
+		ok       bool
+	}{
+		{"", formatJSON, feed.AllMedia, true},
+		{"media=all", formatJSON, feed.AllMedia, true},
+		{"media=small", formatJSON, feed.SmallImages, true},
+		{"", formatRSS, feed.SmallImages, true},
+		{"media=all", formatRSS, feed.SmallImages, true},
+		{"media=large", formatJSON, feed.AllMedia, false},
+	}
+
+	for _, test := range tests {
+		media, ok := parseMediaSelection(httptest.NewRequest(http.MethodGet, "/?id=1234&"+test.query, nil), test.format)
+		if media != test.expected || ok != test.ok {
+			t.Errorf("%q as %s: expected %d %t, got %d %t", test.query, test.format, test.expected, test.ok, media, ok)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+)
+
+// widgetScriptVersion is the version of static/widget.js. Pages referring to the
+// current version with query parameter v can cache the script forever.
+const widgetScriptVersion = "1.0.2"
+
+// widgetScriptMaxAge is how long unversioned script URLs are cached, in seconds
+const widgetScriptMaxAge = 3600
+
+//go:embed static/widget.js
+var widgetScript []byte
+
+// HandleGetWidgetScript serves the JavaScript widget rendering the feed on customer sites
+func (h *sqliteHandler) HandleGetWidgetScript(w http.ResponseWriter, r *http.Request) {
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), len(widgetScript))
+	etag := computeETag(widgetScript, encoding)
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", etag)
+	if r.URL.Query().Get("v") == widgetScriptVersion {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(widgetScriptMaxAge))
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	writeEncodedBody(w, widgetScript, encoding, encodeBody)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestHandleGetWidgetScript(t *testing.T) {
+	h := &sqliteHandler{}
+
+	if !strings.Contains(string(widgetScript), "const VERSION = '"+widgetScriptVersion+"'") {
+		t.Errorf("version of widget.js differs from %s", widgetScriptVersion)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetWidgetScript(rec, httptest.NewRequest(http.MethodGet, "/widget.js?v="+widgetScriptVersion, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(widgetScript) {
+		t.Fatalf("expected 200 with the script, got %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Errorf("unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("expected versioned script to be cached forever, got %s", rec.Header().Get("Cache-Control"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/cgi-bin/bhproxy.cgi/widget.js", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.HandleGetWidgetScript(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("expected unversioned script to be cached shortly, got %s", rec.Header().Get("Cache-Control"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/widget.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.HandleGetWidgetScript(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || decodeBody(t, "gzip", rec.Body.Bytes()) != string(widgetScript) {
+		t.Errorf("expected gzip compressed script")
+	}
+}
+
+// widgetDOM is a minimal DOM for running widget.js in Node. The script is loaded from
+// https://proxy.example.com/cgi-bin/bhproxy.cgi/widget.js and fetch returns FEED. The fetched
+// URL and attributes of the created links and images are printed as JSON when rendering has finished.
+const widgetDOM = `
+const created = []
+function createElement (tagName) {
+  const el = {
+    tagName, dataset: {}, children: [], style: { setProperty () {} },
+    append (...children) { this.children.push(...children) },
+    after () {},
+    attachShadow () { return createElement('#shadow-root') }
+  }
+  created.push(el)
+  return el
+}
+globalThis.document = {
+  createElement,
+  currentScript: Object.assign(createElement('script'), {
+    src: 'https://proxy.example.com/cgi-bin/bhproxy.cgi/widget.js?v=1',
+    dataset: { feedId: '1234' }
+  })
+}
+let feedURL
+globalThis.fetch = async (url) => {
+  feedURL = String(url)
+  return { ok: true, status: 200, json: async () => FEED }
+}
+process.on('beforeExit', () => {
+  const elements = created.filter((el) => el.tagName === 'a' || el.tagName === 'img')
+  console.log(JSON.stringify({ feedURL, elements: elements.map((el) => ({ tagName: el.tagName, href: el.href, src: el.src })) }))
+});
+`
+
+type widgetElement struct {
+	TagName string `json:"tagName"`
+	Href    string `json:"href"`
+	Src     string `json:"src"`
+}
+
+// widgetOutput is the fetched feed URL and the rendered links and images of widget.js
+type widgetOutput struct {
+	FeedURL  string          `json:"feedURL"`
+	Elements []widgetElement `json:"elements"`
+}
+
+// runWidgetScript renders the feed with widget.js in Node
+func runWidgetScript(t *testing.T, feedJSON string) widgetOutput {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	cmd := exec.Command(node, "-")
+	cmd.Stdin = strings.NewReader("const FEED = " + feedJSON + "\n" + widgetDOM + string(widgetScript))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("widget.js failed: %s\n%s", err, output)
+	}
+
+	var result widgetOutput
+	if err := json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("unexpected output of widget.js: %s\n%s", err, output)
+	}
+	return result
+}
+
+func TestWidgetScriptRendersPosts(t *testing.T) {
+	output := runWidgetScript(t, `{"posts": [
+		{"permalink": "https://www.instagram.com/p/1/", "mediaSmallUrl": "/images/post1.webp", "prunedCaption": "First"},
+		{"permalink": "https://www.instagram.com/p/2/", "mediaSmallUrl": "https://cdn.example.org/post2.webp"},
+		{"permalink": "javascript:alert(1)"},
+		{"permalink": "not a URL"}
+	]}`)
+
+	// only the small images are rendered, so no other media is requested
+	if output.FeedURL != "https://proxy.example.com/cgi-bin/bhproxy.cgi/?id=1234&media=small" {
+		t.Errorf("unexpected feed URL %s", output.FeedURL)
+	}
+
+	elements := output.Elements
+	expected := []widgetElement{
+		{TagName: "a", Href: "https://www.instagram.com/p/1/"},
+		{TagName: "img", Src: "https://proxy.example.com/images/post1.webp"},
+		{TagName: "a", Href: "https://www.instagram.com/p/2/"},
+		{TagName: "img", Src: "https://cdn.example.org/post2.webp"},
+		{TagName: "a"},
+		{TagName: "a"},
+	}
+	if len(elements) != len(expected) {
+		t.Fatalf("expected %d links and images, got %+v", len(expected), elements)
+	}
+	for i := range expected {
+		if elements[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], elements[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * bhproxy widget 1.0.2
+ *
+ * Renders Instagram posts served by bhproxy as a responsive grid:
+ *
+ *   <script src="https://example.com/cgi-bin/bhproxy.cgi/widget.js?v=1.0.2"
+ *     data-feed-id="JYK0zcST7PconDbzq1GL" async></script>
+ *
+ * Optional attributes: data-limit, data-min-width (px), data-gap (px),
+ * data-captions ("false" hides captions) and data-proxy-url.
+ */
+(function () {
+  'use strict'
+
+  const VERSION = '1.0.2'
+
+  const STYLE = `
+    :host { display: block; }
+    .bhp-posts { display: grid; grid-template-columns: repeat(auto-fill, minmax(var(--bhp-min-width), 1fr)); gap: var(--bhp-gap); margin: 0; padding: 0; list-style: none; }
+    .bhp-post a { display: block; color: inherit; text-decoration: none; }
+    .bhp-post img { display: block; width: 100%; height: auto; aspect-ratio: 1; object-fit: cover; }
+    .bhp-caption { margin: 0.5em 0 0; font-size: 0.875em; display: -webkit-box; -webkit-line-clamp: 3; -webkit-box-orient: vertical; overflow: hidden; }
+    .bhp-error { color: #b00020; }
+  `
+
+  // feedURL returns the feed endpoint next to the script, e.g. /cgi-bin/bhproxy.cgi/
+  function feedURL (script) {
+    const url = new URL(script.dataset.proxyUrl || './', script.src)
+    url.searchParams.set('id', script.dataset.feedId)
+    // only the small images are rendered, so the proxy downloads no other media
+    url.searchParams.set('media', 'small')
+    if (script.dataset.limit) {
+      url.searchParams.set('limit', script.dataset.limit)
+    }
+    return url
+  }
+
+  function element (name, className, properties) {
+    const el = document.createElement(name)
+    el.className = className || ''
+    return Object.assign(el, properties)
+  }
+
+  // safeLink returns the URL if it is a web link, otherwise undefined so that e.g.
+  // javascript: URLs are never followed
+  function safeLink (ref) {
+    try {
+      const url = new URL(ref)
+      if (url.protocol === 'https:' || url.protocol === 'http:') {
+        return url.href
+      }
+    } catch (error) {}
+    return undefined
+  }
+
+  // media URLs are relative to the proxy, not to the page embedding the widget
+  function renderPosts (root, feed, baseURL, showCaptions) {
+    const list = element('ul', 'bhp-posts')
+    for (const post of feed.posts) {
+      const link = element('a', '', { target: '_blank', rel: 'noopener' })
+      const href = safeLink(post.permalink)
+      if (href) {
+        link.href = href
+      }
+      if (post.mediaSmallUrl) {
+        const image = element('img', '', { src: new URL(post.mediaSmallUrl, baseURL).href, loading: 'lazy', decoding: 'async' })
+        image.alt = (post.prunedCaption || post.caption || '').split('\n')[0]
+        if (post.mediaSmallWidth && post.mediaSmallHeight) {
+          image.width = post.mediaSmallWidth
+          image.height = post.mediaSmallHeight
+        }
+        link.append(image)
+      }
+      if (showCaptions && post.prunedCaption) {
+        // textContent never interprets captions as HTML
+        link.append(element('p', 'bhp-caption', { textContent: post.prunedCaption }))
+      }
+      const item = element('li', 'bhp-post')
+      item.append(link)
+      list.append(item)
+    }
+    root.append(list)
+  }
+
+  async function render (script) {
+    const container = element('div', 'bhproxy-widget')
+    container.dataset.version = VERSION
+    container.style.setProperty('--bhp-min-width', (parseInt(script.dataset.minWidth, 10) || 200) + 'px')
+    container.style.setProperty('--bhp-gap', (parseInt(script.dataset.gap, 10) || 8) + 'px')
+    script.after(container)
+
+    // shadow root keeps the styles of the widget and the host page apart
+    const root = container.attachShadow({ mode: 'open' })
+    root.append(element('style', '', { textContent: STYLE }))
+
+    try {
+      const url = feedURL(script)
+      const response = await fetch(url, { headers: { Accept: 'application/json' } })
+      const body = await response.json()
+      if (!response.ok) {
+        throw new Error(body.error || `HTTP ${response.status}`)
+      }
+      renderPosts(root, body, url, script.dataset.captions !== 'false')
+    } catch (error) {
+      console.error('bhproxy widget:', error)
+      root.append(element('p', 'bhp-error', { textContent: 'Instagram feed could not be loaded.' }))
+    }
+  }
+
+  const script = document.currentScript
+  if (!script || !script.dataset.feedId) {
+    console.error('bhproxy widget: script tag needs data-feed-id attribute')
+    return
+  }
+  render(script)
+})()
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+// maxTimelineFeeds is how many feeds can be merged to a single timeline
+const maxTimelineFeeds = 10
+
+// parseFeedIDs returns the unique IDs of comma-separated list idsStr
+func parseFeedIDs(idsStr string) []string {
+	var ids []string
+	for _, id := range strings.Split(idsStr, ",") {
+		if id = strings.TrimSpace(id); !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// HandleGetTimeline merges several feeds given as comma-separated query parameter ids
+// to a single timeline
+func (h *sqliteHandler) HandleGetTimeline(w http.ResponseWriter, r *http.Request) {
+	idsStr := r.URL.Query().Get("ids")
+	ids := parseFeedIDs(idsStr)
+	setCORSHeaders(w, r, ids...)
+
+	if len(ids) > maxTimelineFeeds {
+		writeError(w, http.StatusBadRequest, codeTooManyFeeds,
+			fmt.Sprintf("Timeline can have at most %d feeds.", maxTimelineFeeds), idsStr)
+		return
+	}
+	for _, id := range ids {
+		if !feed.IsValidFeedID(id) {
+			writeFeedError(w, feed.ErrInvalidFeedID, id)
+			return
+		}
+	}
+
+	limit, ok := parseLimit(w, r, idsStr)
+	if !ok {
+		return
+	}
+
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidFilter, err.Error(), idsStr)
+		return
+	}
+
+	log.Printf("HandleGetTimeline for %s", strings.Join(ids, ","))
+
+	feeds := make([]*feed.Feed, 0, len(ids))
+	var missingIDs []string
+	var firstErr error
+	for _, id := range ids {
+		// each feed is limited so that it alone can fill the timeline
+		f, err := feed.GetFilteredFeed(h.db, h.client, id, limit, filter, feed.AllMedia)
+		if err == nil && f == nil {
+			err = feed.ErrFeedNotExists
+		}
+		if err != nil {
+			log.Printf("error getting feed %s of timeline: %s", id, err)
+			// feeds which are not served at all are errors of the client
+			if errors.Is(err, feed.ErrInvalidFeedID) || errors.Is(err, feed.ErrFeedNotAllowed) {
+				writeFeedError(w, err, id)
+				return
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			missingIDs = append(missingIDs, id)
+			continue
+		}
+		feeds = append(feeds, f)
+	}
+	// the timeline is served as long as any of the feeds is available
+	if len(feeds) == 0 {
+		writeFeedError(w, firstErr, missingIDs[0])
+		return
+	}
+	timeline := feed.MergeFeeds(feeds, limit)
+	timeline.MissingFeedIDs = missingIDs
+
+	body, err := json.Marshal(timeline)
+	if err != nil {
+		log.Println("error encoding timeline to response:", err)
+		writeFeedError(w, err, idsStr)
+		return
+	}
+
+	if timeline.IsStale() {
+		w.Header().Set("X-Bhproxy-Stale", "true")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	entry := cacheEntry{key: "timeline:" + strings.Join(ids, ","), fetchedAt: timeline.FetchedAt, expiresAt: timeline.ExpiresAt}
+	if len(missingIDs) > 0 {
+		w.Header().Set("X-Bhproxy-Partial", "true")
+		// clients must revalidate so that the missing feeds appear once they are available
+		entry.expiresAt = time.Now()
+	}
+	if timeline.HasTemporaryMedia() {
+		// external media URLs are replaced once the files are downloaded and may expire
+		entry.expiresAt = time.Now()
+	}
+	writeCachedResponse(w, r, h.db, entry, append(body, '\n'))
+
+	for _, f := range feeds {
+		h.pruneAfterResponse(w, f)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+func newTestTimelineHandler(t *testing.T) Handler {
+	behold := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/")
+		if id == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"username": "user-%[1]s", "posts": [
+			{"id": "%[1]s-new", "timestamp": "2025-01-0%[2]dT12:00:00+0000", "permalink": "https://www.instagram.com/p/%[1]s1/", "mediaType": "IMAGE"},
+			{"id": "shared", "timestamp": "2025-01-01T00:00:00+0000", "permalink": "https://www.instagram.com/p/shared/", "mediaType": "IMAGE"}
+		]}`, id, len(id))
+	}))
+	t.Cleanup(behold.Close)
+
+	t.Setenv("BHP_BEHOLD_BASE_URL", behold.URL)
+	t.Setenv("BHP_IMAGE_DIRECTORY", t.TempDir())
+	t.Setenv("BHP_ALLOWED_FEED_IDS", "")
+
+	h, err := NewSqliteHandler(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHandleGetTimeline(t *testing.T) {
+	h := newTestTimelineHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.HandleGetTimeline(rec, httptest.NewRequest(http.MethodGet, "/timeline?ids=abc,abcde,abc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var timeline feed.Timeline
+	if err := json.NewDecoder(rec.Body).Decode(&timeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline.Feeds) != 2 {
+		t.Errorf("expected duplicate feed ID to be ignored, got %+v", timeline.Feeds)
+	}
+
+	var posts []string
+	for _, post := range timeline.Posts {
+		posts = append(posts, post.ID+"@"+post.Username)
+	}
+	if strings.Join(posts, ",") != "abcde-new@user-abcde,abc-new@user-abc,shared@user-abc" {
+		t.Errorf("unexpected timeline posts %v", posts)
+	}
+	if rec.Header().Get("ETag") == "" || rec.Header().Get("Cache-Control") == "" {
+		t.Errorf("expected caching headers, got %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleGetTimeline(rec, httptest.NewRequest(http.MethodGet, "/timeline?ids=abc,abcde&limit=1", nil))
+	json.NewDecoder(rec.Body).Decode(&timeline)
+	if len(timeline.Posts) != 1 || timeline.Posts[0].ID != "abcde-new" {
+		t.Errorf("expected only the newest post, got %+v", timeline.Posts)
+	}
+}
+
+func TestHandleGetTimelineErrors(t *testing.T) {
+	h := newTestTimelineHandler(t)
+	t.Setenv("BHP_ALLOWED_FEED_IDS", "abc,missing")
+
+	tests := []struct {
+		ids    string
+		status int
+		code   string
+		feedID string
+	}{
+		{"", http.StatusBadRequest, codeInvalidFeedID, ""},
+		{"abc,../etc", http.StatusBadRequest, codeInvalidFeedID, "../etc"},
+		{"a,b,c,d,e,f,g,h,i,j,k", http.StatusBadRequest, codeTooManyFeeds, "a,b,c,d,e,f,g,h,i,j,k"},
+		{"abc,other", http.StatusForbidden, codeFeedNotAllowed, "other"},
+		{"missing", http.StatusNotFound, codeFeedNotFound, "missing"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		h.HandleGetTimeline(rec, httptest.NewRequest(http.MethodGet, "/timeline?ids="+test.ids, nil))
+
+		var body errorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != test.status || body.Code != test.code || body.FeedID != test.feedID {
+			t.Errorf("%q: expected %d %s for %q, got %d %+v", test.ids, test.status, test.code, test.feedID, rec.Code, body)
+		}
+	}
+}
+
+func TestHandleGetTimelineWithMissingFeed(t *testing.T) {
+	h := newTestTimelineHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.HandleGetTimeline(rec, httptest.NewRequest(http.MethodGet, "/timeline?ids=abc,missing", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the available feeds, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Bhproxy-Partial") != "true" || rec.Header().Get("Cache-Control") != "public, max-age=0" {
+		t.Errorf("expected partial timeline not to be cached, got %v", rec.Header())
+	}
+
+	var timeline feed.Timeline
+	if err := json.NewDecoder(rec.Body).Decode(&timeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline.Feeds) != 1 || timeline.Feeds[0].ID != "abc" || len(timeline.Posts) != 2 {
+		t.Errorf("expected posts of feed abc, got %+v", timeline)
+	}
+	if len(timeline.MissingFeedIDs) != 1 || timeline.MissingFeedIDs[0] != "missing" {
+		t.Errorf("expected missing feed to be listed, got %v", timeline.MissingFeedIDs)
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lattots/bhproxy/pkg/feed"
+)
+
+//go:embed templates/widget.html
+var templateFiles embed.FS
+
+var widgetTemplates = template.Must(template.ParseFS(templateFiles, "templates/widget.html"))
+
+// widget layouts
+const (
+	layoutGrid     = "grid"
+	layoutList     = "list"
+	layoutCarousel = "carousel"
+)
+
+// hexColor matches colors given without the leading # which would start URL fragment
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// widgetOptions are the layout and theme of the widget given as query parameters
+type widgetOptions struct {
+	Layout     string
+	Columns    int
+	Gap        int
+	Radius     int
+	Background string
+	Text       string
+	Accent     string
+	Captions   bool
+	// Fragment omits the surrounding HTML page for including the widget in other pages
+	Fragment bool
+}
+
+// widgetPost is a post prepared for the widget template
+type widgetPost struct {
+	Permalink string
+	ImageURL  string
+	Width     int
+	Height    int
+	Title     string
+	Caption   string
+}
+
+// widgetData is passed to the widget template
+type widgetData struct {
+	widgetOptions
+	Feed       *feed.Feed
+	ProfileURL string
+	Posts      []widgetPost
+}
+
+// parseWidgetOptions reads the widget options from query parameters. The error
+// describes the invalid option for the client.
+func parseWidgetOptions(r *http.Request) (widgetOptions, error) {
+	query := r.URL.Query()
+	options := widgetOptions{
+		Layout:     layoutGrid,
+		Columns:    3,
+		Gap:        8,
+		Radius:     4,
+		Background: "#ffffff",
+		Text:       "#262626",
+		Accent:     "#0095f6",
+		Captions:   true,
+	}
+
+	if layout := query.Get("layout"); layout != "" {
+		if layout != layoutGrid && layout != layoutList && layout != layoutCarousel {
+			return options, fmt.Errorf("Layout must be %s, %s or %s.", layoutGrid, layoutList, layoutCarousel)
+		}
+		options.Layout = layout
+	}
+
+	integers := []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"columns", &options.Columns, 1, 6},
+		{"gap", &options.Gap, 0, 64},
+		{"radius", &options.Radius, 0, 64},
+	}
+	for _, option := range integers {
+		valueStr := query.Get(option.name)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil || value < option.min || value > option.max {
+			return options, fmt.Errorf("Option %s must be an integer from %d to %d.", option.name, option.min, option.max)
+		}
+		*option.value = value
+	}
+
+	colors := []struct {
+		name  string
+		value *string
+	}{
+		{"background", &options.Background},
+		{"text", &options.Text},
+		{"accent", &options.Accent},
+	}
+	for _, option := range colors {
+		color := query.Get(option.name)
+		if color == "" {
+			continue
+		}
+		if !hexColor.MatchString(color) {
+			return options, fmt.Errorf("Option %s must be a hex color, e.g. ffffff.", option.name)
+		}
+		*option.value = "#" + strings.TrimPrefix(color, "#")
+	}
+
+	flags := []struct {
+		name  string
+		value *bool
+	}{
+		{"captions", &options.Captions},
+		{"fragment", &options.Fragment},
+	}
+	for _, option := range flags {
+		valueStr := query.Get(option.name)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.ParseBool(valueStr)
+		if err != nil {
+			return options, fmt.Errorf("Option %s must be true or false.", option.name)
+		}
+		*option.value = value
+	}
+
+	return options, nil
+}
+
+// renderWidget renders the feed as HTML. Captions and other texts are escaped by html/template.
+func renderWidget(r *http.Request, f *feed.Feed, options widgetOptions) ([]byte, error) {
+	data := widgetData{widgetOptions: options, Feed: f, ProfileURL: profileURL(f)}
+	for i := range f.Posts {
+		post := &f.Posts[i]
+		data.Posts = append(data.Posts, widgetPost{
+			Permalink: post.Permalink,
+			// fragments are included in pages of other hosts
+			ImageURL: absoluteURL(r, post.MediaSmallUrl),
+			Width:    post.MediaSmallWidth,
+			Height:   post.MediaSmallHeight,
+			Title:    postTitle(post),
+			Caption:  post.PrunedCaption,
+		})
+	}
+
+	name := "page"
+	if options.Fragment {
+		name = "widget"
+	}
+
+	var buf bytes.Buffer
+	if err := widgetTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("error rendering widget: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// frameAncestors returns Content-Security-Policy allowing the origins of BHP_CORS_ALLOWED_ORIGINS
+// to embed the widget in an iframe. Empty string means that any site may embed it.
+func frameAncestors(feedID string) string {
+	sources := []string{"'self'"}
+	for _, origin := range getAllowedOrigins(feedID) {
+		if origin == "*" {
+			return ""
+		}
+		sources = append(sources, origin)
+	}
+	return "frame-ancestors " + strings.Join(sources, " ")
+}
+
+// HandleGetWidget renders the feed as embeddable HTML for sites which can't run JavaScript
+func (h *sqliteHandler) HandleGetWidget(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	setCORSHeaders(w, r, id)
+	if !feed.IsValidFeedID(id) {
+		writeFeedError(w, feed.ErrInvalidFeedID, id)
+		return
+	}
+
+	limit, ok := parseLimit(w, r, id)
+	if !ok {
+		return
+	}
+
+	options, err := parseWidgetOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidWidgetOption, err.Error(), id)
+		return
+	}
+
+	filter, err := parsePostFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidFilter, err.Error(), id)
+		return
+	}
+
+	log.Printf("HandleGetWidget for %s", id)
+
+	f := h.getFeed(w, id, limit, filter, feed.SmallImages)
+	if f == nil {
+		return
+	}
+
+	body, err := renderWidget(r, f, options)
+	if err != nil {
+		log.Println("error rendering widget to response:", err)
+		writeFeedError(w, err, id)
+		return
+	}
+
+	if f.IsStale() {
+		w.Header().Set("X-Bhproxy-Stale", "true")
+	}
+	if policy := frameAncestors(id); policy != "" {
+		w.Header().Set("Content-Security-Policy", policy)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeCachedResponse(w, r, h.db, feedCacheEntry(f), body)
+
+	h.pruneAfterResponse(w, f)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseWidgetOptions(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/widget?id=1234&layout=carousel&columns=4&gap=0&background=000&text=%23FFFFFF&captions=false", nil)
+	options, err := parseWidgetOptions(req)
+	if err != nil {
+		t.Fatalf("parseWidgetOptions returned an error: %s", err)
+	}
+	if options.Layout != layoutCarousel || options.Columns != 4 || options.Gap != 0 || options.Radius != 4 {
+		t.Errorf("unexpected layout options %+v", options)
+	}
+	if options.Background != "#000" || options.Text != "#FFFFFF" || options.Accent != "#0095f6" || options.Captions {
+		t.Errorf("unexpected theme options %+v", options)
+	}
+
+	invalidQueries := []string{
+		"layout=masonry",
+		"columns=0",
+		"columns=7",
+		"gap=big",
+		"background=red",
+		"accent=fff%3Bbackground:url(x)",
+		"text=12345",
+		"captions=maybe",
+	}
+	for _, query := range invalidQueries {
+		req := httptest.NewRequest(http.MethodGet, "/widget?id=1234&"+query, nil)
+		if _, err := parseWidgetOptions(req); err == nil {
+			t.Errorf("expected %s to be invalid", query)
+		}
+	}
+}
+
+func TestRenderWidget(t *testing.T) {
+	f := newTestFeed()
+	f.Posts[0].PrunedCaption = `<script>alert("x")</script> & friends`
+	f.Posts[0].Permalink = `javascript:alert(1)`
+
+	req := httptest.NewRequest(http.MethodGet, "https://proxy.example.com/widget?id=1234&background=123456", nil)
+	options, err := parseWidgetOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := renderWidget(req, f, options)
+	if err != nil {
+		t.Fatalf("renderWidget returned an error: %s", err)
+	}
+	html := string(body)
+
+	if !strings.HasPrefix(html, "<!DOCTYPE html>") {
+		t.Errorf("expected full page, got %s", html)
+	}
+	if strings.Contains(html, "<script>") || !strings.Contains(html, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; friends") {
+		t.Errorf("expected caption to be escaped, got %s", html)
+	}
+	if strings.Contains(html, "javascript:") {
+		t.Errorf("expected unsafe permalink to be filtered, got %s", html)
+	}
+	if !strings.Contains(html, "--bhp-background: #123456") || !strings.Contains(html, `class="bhp-widget bhp-grid"`) {
+		t.Errorf("expected theme variables and layout, got %s", html)
+	}
+	if !strings.Contains(html, `src="https://proxy.example.com/images/post1.webp"`) {
+		t.Errorf("expected absolute image URL, got %s", html)
+	}
+
+	options.Fragment = true
+	body, err = renderWidget(req, f, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), `<div class="bhp-widget`) {
+		t.Errorf("expected only the widget fragment, got %s", body)
+	}
+}
+
+func TestFrameAncestors(t *testing.T) {
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS", "")
+	t.Setenv("BHP_CORS_ALLOWED_ORIGINS_PER_FEED", "customer=https://customer.fi https://www.customer.fi,open=*")
+
+	tests := map[string]string{
+		"1234":     "frame-ancestors 'self'",
+		"customer": "frame-ancestors 'self' https://customer.fi https://www.customer.fi",
+		"open":     "",
+	}
+	for feedID, expected := range tests {
+		if policy := frameAncestors(feedID); policy != expected {
+			t.Errorf("%s: expected %q, got %q", feedID, expected, policy)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func FileExists(filepath string) bool {
@@ -27,3 +28,18 @@ func GetDotEnvPath() string {
 
 	return filepath.Join(filepath.Dir(executable), ".env")
 }
+
+// GetFeedSetting returns the value of environment variable name for the given feed.
+// Per-feed overrides are read from variable name_PER_FEED which holds comma-separated
+// feedID=value pairs. If the feed has no override, the global value of name is returned.
+func GetFeedSetting(name, feedID string) string {
+	overrides := strings.Split(os.Getenv(name+"_PER_FEED"), ",")
+	for _, override := range overrides {
+		overrideFeedID, value, found := strings.Cut(strings.TrimSpace(override), "=")
+		if found && overrideFeedID == feedID {
+			return value
+		}
+	}
+
+	return os.Getenv(name)
+}
@@ -39,3 +39,18 @@ func TestFileIsWriteable(t *testing.T) {
 		t.Errorf("FileIsWriteable returns true although file should not be writeable")
 	}
 }
+
+func TestGetFeedSetting(t *testing.T) {
+	t.Setenv("BHP_TEST_SETTING", "24h")
+	t.Setenv("BHP_TEST_SETTING_PER_FEED", "feed1=1h, feed2=168h")
+
+	if value := GetFeedSetting("BHP_TEST_SETTING", "feed1"); value != "1h" {
+		t.Errorf("expected per-feed value 1h, got %s", value)
+	}
+	if value := GetFeedSetting("BHP_TEST_SETTING", "feed2"); value != "168h" {
+		t.Errorf("expected per-feed value 168h, got %s", value)
+	}
+	if value := GetFeedSetting("BHP_TEST_SETTING", "feed3"); value != "24h" {
+		t.Errorf("expected global value 24h, got %s", value)
+	}
+}